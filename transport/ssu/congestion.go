@@ -0,0 +1,163 @@
+package ssu
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// initialWindowSegments is the initial congestion window, in maximum-sized fragments, as in RFC 3390
+	initialWindowSegments = 4
+
+	// minWindowSegments is the lowest the congestion window goes on loss, in maximum-sized fragments
+	minWindowSegments = 2
+
+	// maxWindow is the highest the congestion window goes, in bytes
+	maxWindow = 256 * 1024
+)
+
+// CongestionState is a snapshot of the congestion control state of a session, for monitoring
+type CongestionState struct {
+	// Window is the number of bytes which may be in flight
+	Window int
+
+	// SlowStartThreshold is the window size up to which it grows exponentially, and linearly afterwards
+	SlowStartThreshold int
+
+	// InFlight is the number of bytes sent but not yet acknowledged
+	InFlight int
+
+	// SlowStart is true while the window grows exponentially
+	SlowStart bool
+
+	// RTT is the smoothed round-trip time, and RTO the retransmission timeout
+	RTT time.Duration
+	RTO time.Duration
+}
+
+// congestionWindow limits the bytes in flight of a session, with slow start and AIMD as in RFC 5681
+// It only reacts to the losses detected by retransmission timeouts: as Java I2P sends many duplicate ACKs,
+// these carry no meaning and merely acknowledge nothing new.
+type congestionWindow struct {
+	mu sync.Mutex
+
+	// Maximum segment size, that is the size of a maximum-sized fragment with its header
+	mss int
+
+	window   int
+	ssthresh int
+	inFlight int
+
+	// Time of the last window reduction, as a burst of losses only reduces it once
+	lastReduction time.Time
+
+	// Closed and replaced whenever room is made in the window
+	wake chan struct{}
+}
+
+func newCongestionWindow(mss int) *congestionWindow {
+	return &congestionWindow{
+		mss:      mss,
+		window:   initialWindowSegments * mss,
+		ssthresh: maxWindow,
+		wake:     make(chan struct{}),
+	}
+}
+
+// fragmentCost returns the number of bytes a fragment occupies in the congestion window
+func fragmentCost(f dataFragment) int {
+	return fragmentHeaderLen + len(f.Data)
+}
+
+// acquire waits for n bytes to fit in the window and accounts them as in flight
+// It returns false if cancel is closed first. Whatever the window, a single fragment may always be in flight.
+func (w *congestionWindow) acquire(n int, cancel <-chan struct{}) bool {
+	for {
+		w.mu.Lock()
+		if w.inFlight == 0 || w.inFlight+n <= w.window {
+			w.inFlight += n
+			w.mu.Unlock()
+			return true
+		}
+		wake := w.wake
+		w.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// acked handles n bytes newly acknowledged, growing the window
+func (w *congestionWindow) acked(n int) {
+	if n == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inFlight -= n
+	if w.window < w.ssthresh {
+		// Slow start: grow by the bytes acknowledged, doubling each RTT
+		w.window += n
+	} else {
+		// Congestion avoidance: grow by a segment each RTT
+		w.window += w.mss * n / w.window
+	}
+	if w.window > maxWindow {
+		w.window = maxWindow
+	}
+	w.notify()
+}
+
+// released handles n bytes which won't be acknowledged, as their message was abandoned
+func (w *congestionWindow) released(n int) {
+	if n == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inFlight -= n
+	w.notify()
+}
+
+// lost handles a loss, halving the window unless it was already reduced during the last rto
+func (w *congestionWindow) lost(now time.Time, rto time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if now.Sub(w.lastReduction) < rto {
+		return
+	}
+	w.lastReduction = now
+
+	w.ssthresh = w.window / 2
+	if min := minWindowSegments * w.mss; w.ssthresh < min {
+		w.ssthresh = min
+	}
+	w.window = w.ssthresh
+}
+
+// notify wakes up the senders waiting for room in the window
+func (w *congestionWindow) notify() {
+	close(w.wake)
+	w.wake = make(chan struct{})
+}
+
+// state returns a snapshot of the window
+func (w *congestionWindow) state() CongestionState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return CongestionState{
+		Window:             w.window,
+		SlowStartThreshold: w.ssthresh,
+		InFlight:           w.inFlight,
+		SlowStart:          w.window < w.ssthresh,
+	}
+}
@@ -0,0 +1,150 @@
+package ssu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCongestionWindow_SlowStart(t *testing.T) {
+	const mss = 1000
+	w := newCongestionWindow(mss)
+	if s := w.state(); s.Window != initialWindowSegments*mss || !s.SlowStart {
+		t.Fatalf("unexpected initial state %+v", s)
+	}
+
+	// Acknowledging a window's worth of bytes doubles it
+	w.acquire(initialWindowSegments*mss, nil)
+	w.acked(initialWindowSegments * mss)
+	if s := w.state(); s.Window != 2*initialWindowSegments*mss || s.InFlight != 0 {
+		t.Errorf("unexpected state after slow start %+v", s)
+	}
+}
+
+func TestCongestionWindow_AIMD(t *testing.T) {
+	const mss = 1000
+	w := newCongestionWindow(mss)
+	w.window = 20 * mss
+	now := time.Now()
+
+	// A loss halves the window, ending slow start
+	w.lost(now, time.Second)
+	if s := w.state(); s.Window != 10*mss || s.SlowStartThreshold != 10*mss || s.SlowStart {
+		t.Fatalf("unexpected state after loss %+v", s)
+	}
+
+	// Losses within the same RTO don't reduce it further
+	w.lost(now.Add(500*time.Millisecond), time.Second)
+	if s := w.state(); s.Window != 10*mss {
+		t.Errorf("window reduced twice for a burst of losses: %+v", s)
+	}
+
+	// Acknowledging a window's worth of bytes then grows it by about a segment
+	w.acquire(10*mss, nil)
+	for i := 0; i < 10; i++ {
+		w.acked(mss)
+	}
+	if s := w.state(); s.Window < 10*mss+mss*9/10 || s.Window > 11*mss {
+		t.Errorf("unexpected window after congestion avoidance: %+v", s)
+	}
+
+	// It never goes below the minimum
+	for i := 1; i <= 10; i++ {
+		w.lost(now.Add(time.Duration(i)*time.Second), time.Second)
+	}
+	if s := w.state(); s.Window != minWindowSegments*mss {
+		t.Errorf("expected the window to be bounded by %d, got %+v", minWindowSegments*mss, s)
+	}
+}
+
+func TestCongestionWindow_Acquire(t *testing.T) {
+	const mss = 1000
+	w := newCongestionWindow(mss)
+
+	// Fill the window
+	if !w.acquire(initialWindowSegments*mss, nil) {
+		t.Fatal("acquire failed on an empty window")
+	}
+
+	// The next acquisition blocks until room is made
+	acquired := make(chan bool, 1)
+	go func() { acquired <- w.acquire(mss, nil) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire didn't block on a full window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.acked(mss)
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Error("acquire failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire still blocked after an ACK")
+	}
+
+	// And is cancellable
+	cancel := make(chan struct{})
+	close(cancel)
+	if s := w.state(); !w.acquire(s.Window-s.InFlight, nil) {
+		t.Fatal("acquire failed on the remaining room")
+	}
+	if w.acquire(mss, cancel) {
+		t.Error("acquire succeeded on a full window despite being cancelled")
+	}
+}
+
+func TestRetransmitter_DuplicateACKs(t *testing.T) {
+	const mss = fragmentHeaderLen + 100
+	r := newRetransmitter(newCongestionWindow(mss))
+	fragments, _ := fragmentMessage(1, make([]byte, 300), 100)
+	now := time.Now()
+	trackSent(r, 1, fragments, now)
+
+	// Partially acknowledging the same fragments again and again only counts them once
+	var received fragmentSet
+	received.add(0)
+	for i := 0; i < 5; i++ {
+		r.ackPartial(1, received, now)
+	}
+	state := r.state()
+	if state.InFlight != 2*mss {
+		t.Errorf("expected %d bytes in flight, got %d", 2*mss, state.InFlight)
+	}
+	if state.Window != (initialWindowSegments+1)*mss {
+		t.Errorf("expected the window to grow by a single segment, got %d", state.Window)
+	}
+
+	// Duplicate ACKs of a completed message don't affect the window either
+	r.ack(1, now)
+	state = r.state()
+	for i := 0; i < 5; i++ {
+		r.ack(1, now)
+	}
+	if r.state() != state {
+		t.Errorf("duplicate ACKs changed the state: %+v != %+v", r.state(), state)
+	}
+}
+
+func TestRetransmitter_LossOnlyInFlight(t *testing.T) {
+	const mss = fragmentHeaderLen + 100
+	r := newRetransmitter(newCongestionWindow(mss))
+	fragments, _ := fragmentMessage(1, make([]byte, 300), 100)
+	now := time.Now()
+
+	// A message whose fragments are all waiting for room in the window times out without shrinking it
+	r.track(1, fragments)
+	window := r.state().Window
+	r.due(now.Add(initialRTO))
+	if state := r.state(); state.Window != window {
+		t.Errorf("window shrank from %d to %d with nothing in flight", window, state.Window)
+	}
+
+	// Whereas one in flight does
+	fragments, _ = fragmentMessage(2, make([]byte, 300), 100)
+	trackSent(r, 2, fragments, now)
+	r.due(now.Add(initialRTO))
+	if state := r.state(); state.Window >= window {
+		t.Errorf("window of %d not reduced by a loss", state.Window)
+	}
+}
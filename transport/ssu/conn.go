@@ -2,37 +2,512 @@ package ssu
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"net"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/monnand/dhkx"
+)
+
+const (
+	// Maximum sizes of the datagrams we send, excluding the IP and UDP headers
+	ipv4MTU = 1500 - 20 - 8
+	ipv6MTU = 1500 - 40 - 8
+
+	// inboundQueueSize is the number of reassembled messages waiting to be read
+	inboundQueueSize = 64
+
+	// expireInterval is the interval between expirations of incomplete inbound messages
+	expireInterval = time.Second
 )
 
+// ErrSessionDestroyed is returned by Read and Write once the peer destroyed the session
+var ErrSessionDestroyed = errors.New("session destroyed by the peer")
+
 // Conn is a SSU connection
+// Each Write sends a message, which is fragmented as needed, and each message received is read in order by Read
+// ReadMessage and WriteMessage do the same with I2NP messages, translating their short header.
 type Conn struct {
-	sessionKey        []byte
+	keys              *keyring
 	underlying        net.Conn
 	underlyingForeign bool
+
+	// Options of the session
+	opts *Dialer
+
+	// Maximum size of the datagrams we send
+	mtu int
+
+	// Datagrams received recently, to drop the replayed ones
+	replays *replayCache
+
+	// Inbound messages, being reassembled then waiting to be read
+	reassembler *reassembler
+	inbound     chan receivedMessage
+
+	// Outbound messages waiting to be acknowledged, and the congestion window limiting them
+	retransmitter *retransmitter
+
+	// Handles the messages received other than data and session destroyed, if any
+	control controlHandler
+
+	// Relay tag of the session, zero if none
+	// As Alice, it is the tag Bob offered to introduce us; as Bob, the one we issued
+	relayTag uint32
+
+	// Remainder of the message being read
+	readMu  sync.Mutex
+	readBuf []byte
+
+	// Deadlines of the reads and writes
+	readDeadline  deadline
+	writeDeadline deadline
+
+	// Closed once the reading loop exits
+	readDone chan struct{}
+
+	// As Alice, stops resending the Session Confirmed, once Bob answered over the session
+	stopConfirming func()
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+// receivedMessage is a message reassembled, waiting to be read
+type receivedMessage struct {
+	id   uint32
+	data []byte
+}
+
+// controlHandler handles a message received over an established session, other than data and session destroyed
+type controlHandler func(conn *Conn, payloadType byte, payload []byte)
+
+// newConn creates a Conn over an established session with the given options, and starts receiving datagrams
+// As Alice, the datagrams of the Session Confirmed are resent until the first datagram of the session arrives.
+func newConn(underlying net.Conn, foreign bool, sessionKey []byte, macKey []byte, confirmed [][]byte, control controlHandler, opts *Dialer) *Conn {
+	conn := &Conn{
+		keys:              newKeyring(sessionKey, macKey),
+		underlying:        underlying,
+		underlyingForeign: foreign,
+		opts:              opts,
+		mtu:               ipv4MTU,
+		replays:           newReplayCache(opts.maxClockSkew()),
+		reassembler:       newReassembler(),
+		inbound:           make(chan receivedMessage, inboundQueueSize),
+		control:           control,
+		readDeadline:      makeDeadline(),
+		writeDeadline:     makeDeadline(),
+		readDone:          make(chan struct{}),
+		stopConfirming:    func() {},
+		closed:            make(chan struct{}),
+	}
+
+	// IPv6 headers are larger
+	if raddr, ok := underlying.RemoteAddr().(*net.UDPAddr); ok && raddr.IP.To4() == nil {
+		conn.mtu = ipv6MTU
+	}
+	conn.retransmitter = newRetransmitter(newCongestionWindow(fragmentHeaderLen + conn.maxFragmentSize()))
+
+	// The Session Confirmed may be lost, and Bob's answer too, but not resent forever
+	if len(confirmed) != 0 {
+		conn.stopConfirming = resend(underlying, confirmed...)
+		time.AfterFunc(handshakeTimeout, conn.stopConfirming)
+	}
+
+	go conn.readLoop()
+	go conn.retransmitLoop()
+	conn.startRekeying(opts.RekeyInterval)
+	return conn
+}
+
+// authenticates checks whether a datagram is authenticated with one of the session's MAC keys
+func (conn *Conn) authenticates(b []byte) bool {
+	_, _, ok := conn.keys.lookup(b, time.Now())
+	return ok
+}
+
+// maxFragmentSize returns the size of the largest fragment which fits alone in a datagram, whatever its padding
+func (conn *Conn) maxFragmentSize() int {
+	return conn.mtu - nominalHeaderLen - 15 - (1 + 1) - fragmentHeaderLen
 }
 
 // Read reads data from the connection.
 // Read can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
-func (conn *Conn) Read(b []byte) (n int, err error) { return }
+func (conn *Conn) Read(b []byte) (n int, err error) {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+
+	// Once the deadline is exceeded, fail even if there is something to read
+	if isClosedChan(conn.readDeadline.wait()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	// Wait for a message if we have finished reading the previous one
+	if len(conn.readBuf) == 0 {
+		select {
+		case msg := <-conn.inbound:
+			conn.readBuf = msg.data
+		case <-conn.closed:
+			return 0, conn.err
+		case <-conn.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	// Copy what we can
+	n = copy(b, conn.readBuf)
+	conn.readBuf = conn.readBuf[n:]
+	return n, nil
+}
 
 // Write writes data to the connection.
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
-func (conn *Conn) Write(b []byte) (n int, err error) { return }
+//
+// Write returns once the message has been acknowledged by the peer, its fragments being resent until then.
+// If it isn't acknowledged after several attempts, Write returns ErrDeliveryFailed.
+func (conn *Conn) Write(b []byte) (n int, err error) {
+	id, err := randomMessageID()
+	if err != nil {
+		return 0, err
+	}
+	err = conn.writeMessage(context.Background(), id, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeMessage sends a message with the given ID, returning once it is acknowledged by the peer
+// If ctx is done or the write deadline exceeded first, the message is abandoned.
+func (conn *Conn) writeMessage(ctx context.Context, id uint32, b []byte) error {
+	select {
+	case <-conn.closed:
+		return conn.err
+	case <-conn.writeDeadline.wait():
+		return os.ErrDeadlineExceeded
+	default:
+	}
+
+	// Fragment the message
+	fragments, err := fragmentMessage(id, b, conn.maxFragmentSize())
+	if err != nil {
+		return err
+	}
+
+	// Stop waiting for the congestion window or the acknowledgment once ctx is done, the write deadline exceeded or
+	// the connection closed
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-conn.closed:
+		case <-conn.writeDeadline.wait():
+		case <-wctx.Done():
+		}
+		cancel()
+	}()
+
+	// Send each fragment in its own datagram, as the congestion window allows
+	done := conn.retransmitter.track(id, fragments)
+	for _, f := range fragments {
+		if !conn.retransmitter.cwnd.acquire(fragmentCost(f), wctx.Done()) {
+			break
+		}
+		if !conn.retransmitter.markSent(f, time.Now()) {
+			// The message failed in the meantime
+			conn.retransmitter.cwnd.released(fragmentCost(f))
+			break
+		}
+		err = conn.writeFragment(f)
+		if err != nil {
+			conn.retransmitter.forget(id)
+			return err
+		}
+	}
+
+	// Wait for the acknowledgment
+	select {
+	case err = <-done:
+		return err
+	case <-wctx.Done():
+		conn.retransmitter.forget(id)
+		switch {
+		case isClosedChan(conn.closed):
+			return conn.err
+		case isClosedChan(conn.writeDeadline.wait()):
+			return os.ErrDeadlineExceeded
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// randomMessageID returns a random message ID
+func randomMessageID() (uint32, error) {
+	var b [4]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// writeDatagram sends a datagram with the given keys, padded according to our padding policy
+// It is marshalled into a buffer from datagramBufferPool, which is given back once sent
+func (conn *Conn) writeDatagram(d *datagram, keys sessionKeys) error {
+	d.Time = uint32(time.Now().Unix())
+	d.Size = paddedSize(conn.opts.Padding, d, conn.mtu)
+
+	// Marshal it
+	bp := datagramBufferPool.Get().(*[]byte)
+	defer datagramBufferPool.Put(bp)
+	if cap(*bp) < d.outputLen() {
+		return errors.New("datagram too large")
+	}
+	b := (*bp)[:d.outputLen()]
+	if err := d.MarshalBinaryTo(b, keys.macKey, keys.sessionKey); err != nil {
+		return err
+	}
+
+	// Send it
+	_, err := conn.underlying.Write(b)
+	return err
+}
+
+// writeData sends a data message
+func (conn *Conn) writeData(dm *dataMessage) error {
+	b, err := dm.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return conn.writeControl(payloadData, b)
+}
+
+// writeControl sends a message other than data over the session
+func (conn *Conn) writeControl(payloadType byte, payload []byte) error {
+	d := &datagram{
+		Flag:    composeFlag(payloadType, false, false),
+		Payload: payload,
+	}
+	return conn.writeDatagram(d, conn.keys.keys())
+}
+
+// writeFragment sends a fragment in its own datagram
+func (conn *Conn) writeFragment(f dataFragment) error {
+	return conn.writeData(&dataMessage{
+		WantReply: true,
+		Fragments: []dataFragment{f},
+	})
+}
+
+// retransmitLoop resends the unacknowledged fragments until the connection is closed
+func (conn *Conn) retransmitLoop() {
+	ticker := time.NewTicker(retransmitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, f := range conn.retransmitter.due(now) {
+				conn.writeFragment(f)
+			}
+			if material, keys, ok := conn.keys.due(now); ok {
+				conn.writeRekey(material, keys)
+			}
+		case <-conn.closed:
+			return
+		}
+	}
+}
+
+// readLoop receives the datagrams of the session until the connection is closed
+func (conn *Conn) readLoop() {
+	// Hand the underlying connection back without the deadline which unblocked us, if it isn't ours
+	defer close(conn.readDone)
+	if conn.underlyingForeign {
+		defer conn.underlying.SetReadDeadline(time.Time{})
+	}
+	defer conn.stopConfirming()
+
+	buf := make([]byte, maximumDatagramSize)
+	lastExpire := time.Now()
+	for {
+		// Read a datagram
+		n, err := conn.underlying.Read(buf)
+		if err != nil {
+			conn.close(err, false)
+			return
+		}
+
+		// Drop the expired incomplete messages from time to time
+		now := time.Now()
+		if now.Sub(lastExpire) >= expireInterval {
+			conn.reassembler.expire(now)
+			lastExpire = now
+		}
+
+		// Unmarshal it, discarding it if it isn't authenticated with the session keys
+		// Notably, session destroyed messages using an intro key are thus ignored, as the spec requires
+		keys, pending, ok := conn.keys.lookup(buf[:n], now)
+		if !ok {
+			continue
+		}
+		d := new(datagram)
+		if err := d.unmarshal(buf[:n], keys.macKey, keys.sessionKey); err != nil {
+			continue
+		}
+
+		// As Alice, Bob answering over the session means it received our Session Confirmed
+		// As Bob, Alice resending hers means she didn't receive our answer: answer again, even if it is a replay
+		conn.stopConfirming()
+		if p, _, _ := decomposeFlag(d.Flag); p == payloadSessionConfirmed {
+			conn.writeData(new(dataMessage))
+			continue
+		}
+
+		// Drop it if it is outdated or replayed
+		if !conn.checkReplay(buf[:n], d, now) {
+			continue
+		}
+
+		// The peer using the keys we proposed means it received them, and keying material must be answered
+		if pending {
+			conn.keys.promote(now)
+		}
+		if _, rekey, _ := decomposeFlag(d.Flag); rekey && conn.keys.received(d.KeyingMaterial, keys, now) {
+			conn.writeRekey(d.KeyingMaterial, keys)
+		}
+
+		// Handle it
+		switch p, _, _ := decomposeFlag(d.Flag); p {
+		case payloadData:
+			dm := new(dataMessage)
+			if err := dm.UnmarshalBinary(d.Payload); err != nil {
+				continue
+			}
+			conn.handleData(dm, now)
+		case payloadSessionDestroyed:
+			conn.close(ErrSessionDestroyed, false)
+			return
+		default:
+			if conn.control != nil {
+				conn.control(conn, p, d.Payload)
+			}
+		}
+	}
+}
 
-// Close closes the connection.
+// handleData processes the ACKs of a data message and reassembles its fragments, queueing the completed messages to be read
+func (conn *Conn) handleData(dm *dataMessage, now time.Time) {
+	// Process the ACKs of our messages
+	for _, id := range dm.ACKs {
+		conn.retransmitter.ack(id, now)
+	}
+	for _, bf := range dm.ACKBitfields {
+		conn.retransmitter.ackPartial(bf.MessageID, bf.Received, now)
+	}
+
+	// Reassemble the fragments
+	for _, f := range dm.Fragments {
+		msg, err := conn.reassembler.add(f, now)
+		if err != nil || msg == nil {
+			continue
+		}
+
+		// Drop it if it was already received, though it is still acknowledged
+		if conn.opts.Duplicates != nil && conn.opts.Duplicates.check(f.MessageID, now) {
+			continue
+		}
+
+		// As with UDP, if the reader is too slow the message is dropped
+		select {
+		case conn.inbound <- receivedMessage{id: f.MessageID, data: msg}:
+		default:
+		}
+	}
+
+	// Acknowledge them
+	if ack := conn.acknowledgment(dm.Fragments); len(ack.ACKs) != 0 || len(ack.ACKBitfields) != 0 {
+		conn.writeData(ack)
+	}
+}
+
+// acknowledgment builds the data message acknowledging the messages of the fragments received:
+// the completed ones explicitly, the others with a bitfield of their fragments received so far
+func (conn *Conn) acknowledgment(fragments []dataFragment) *dataMessage {
+	ack := new(dataMessage)
+	seen := make(map[uint32]bool, len(fragments))
+	for _, f := range fragments {
+		if seen[f.MessageID] {
+			continue
+		}
+		seen[f.MessageID] = true
+
+		received, complete := conn.reassembler.ackState(f.MessageID)
+		switch {
+		case complete:
+			ack.ACKs = append(ack.ACKs, f.MessageID)
+		case received.len() != 0:
+			ack.ACKBitfields = append(ack.ACKBitfields, ackBitfield{MessageID: f.MessageID, Received: received})
+		}
+	}
+	return ack
+}
+
+// Close closes the connection, telling the peer that the session is destroyed.
 // Any blocked Read or Write operations will be unblocked and return errors.
+// A connection given to DialOverConn is left open, and usable once Close returns.
 func (conn *Conn) Close() error {
-	if !conn.underlyingForeign {
-		return conn.underlying.Close()
+	conn.close(net.ErrClosed, true)
+
+	// The underlying connection may be used again once we stopped reading it
+	if conn.underlyingForeign {
+		<-conn.readDone
 	}
 	return nil
 }
 
+// close tears down the connection with the given error, which is returned by subsequent Read and Write calls
+// If notifyPeer is set, a session destroyed message is sent beforehand
+func (conn *Conn) close(err error, notifyPeer bool) {
+	conn.closeOnce.Do(func() {
+		// It has no payload, and is best-effort
+		if notifyPeer {
+			conn.writeControl(payloadSessionDestroyed, nil)
+		}
+
+		conn.err = err
+		close(conn.closed)
+		conn.retransmitter.fail(err)
+
+		// Close the underlying connection if it's ours, else just unblock the reading loop
+		if !conn.underlyingForeign {
+			conn.underlying.Close()
+		} else {
+			conn.underlying.SetReadDeadline(time.Now())
+		}
+	})
+}
+
+// RelayTag returns the relay tag Bob offered when we established the session, to be published along with its address
+// to be introduced through it. It is zero if Bob didn't offer to be our introducer.
+// For sessions established by the remote peer, it is the relay tag we offered it.
+func (conn *Conn) RelayTag() uint32 {
+	return conn.relayTag
+}
+
+// CongestionState returns a snapshot of the congestion control state of the session
+func (conn *Conn) CongestionState() CongestionState {
+	return conn.retransmitter.state()
+}
+
 // LocalAddr returns the local network address.
 func (conn *Conn) LocalAddr() net.Addr { return conn.underlying.LocalAddr() }
 
@@ -54,37 +529,86 @@ func (conn *Conn) RemoteAddr() net.Addr { return conn.underlying.RemoteAddr() }
 // the deadline after successful Read or Write calls.
 //
 // A zero value for t means I/O operations will not time out.
-func (conn *Conn) SetDeadline(t time.Time) error { return nil }
+func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	conn.writeDeadline.set(t)
+	return nil
+}
 
 // SetReadDeadline sets the deadline for future Read calls
 // and any currently-blocked Read call.
 // A zero value for t means Read will not time out.
-func (conn *Conn) SetReadDeadline(t time.Time) error { return nil }
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	return nil
+}
 
 // SetWriteDeadline sets the deadline for future Write calls
 // and any currently-blocked Write call.
-// Even if write times out, it may return n > 0, indicating that
-// some of the data was successfully written.
+// As a message is written whole, a Write which times out returns n == 0,
+// though the message may still be delivered.
 // A zero value for t means Write will not time out.
-func (conn *Conn) SetWriteDeadline(t time.Time) error { return nil }
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	conn.writeDeadline.set(t)
+	return nil
+}
 
 // A Dialer contains options for connecting to a remote peer
 type Dialer struct {
-	SigningPubKey  []byte
-	SigningPrivKey []byte
-	Introkey       []byte
+	// RouterIdentity is our binary RouterIdentity, sent to the remote peer during session establishment
+	RouterIdentity []byte
+
+	// Signer signs with the signing private key matching our RouterIdentity
+	Signer Signer
+
+	Introkey []byte
+
+	// MaxIntroducedPeers is the number of peers a Transport offers to introduce at once, acting as introducer
+	// Zero disables introductions
+	MaxIntroducedPeers int
+
+	// RekeyInterval is the interval between the rekeyings of the sessions we establish or accept
+	// Zero disables periodic rekeying
+	RekeyInterval time.Duration
+
+	// Padding chooses the size of the datagrams of the sessions we establish or accept
+	// If nil, they are only padded to the next 16-byte boundary
+	Padding PaddingPolicy
+
+	// MaxClockSkew is the largest difference allowed between the time of the datagrams we receive and ours
+	// Zero means one minute
+	MaxClockSkew time.Duration
+
+	// OnDrop, if set, is called whenever an authenticated datagram is dropped, with a *ClockSkewError if its time is
+	// beyond the allowed clock skew, or ErrReplayedDatagram if it was already received over its session
+	OnDrop func(addr net.Addr, err error)
+
+	// Duplicates, if set, drops the messages received recently over any of the sessions sharing it
+	// A Transport uses one of its own for all its sessions if none is given.
+	Duplicates *DuplicateFilter
+
+	// NoRelayTag tells the peers we dial that we don't need them to introduce us, so that they don't issue a relay tag
+	// It relies on extended options, which routers older than release 0.9.24 don't support
+	NoRelayTag bool
 }
 
-// Dial does a direct dial
-func (d *Dialer) Dial(ctx context.Context, peer *net.UDPAddr, introkey []byte) (*Conn, error) {
+// Dial does a direct dial to a peer, given its intro key and binary RouterIdentity
+func (d *Dialer) Dial(ctx context.Context, peer *net.UDPAddr, introkey []byte, identity []byte) (*Conn, error) {
 	// Dial UDP
 	udpConn, err := net.DialUDP("udp", nil, peer)
 	if err != nil {
 		return nil, err
 	}
 
-	// Pass it over to DialOverConn
-	return d.DialOverConn(ctx, udpConn, introkey, peer.IP)
+	// Establish the session
+	hs, err := d.handshake(ctx, udpConn, peer, introkey, identity)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+
+	// As we created the UDP connection, we are the ones who must close it
+	return hs.newConn(udpConn, false, nil), nil
 }
 
 // DialOverConn does a direct dial over a pre-established net.Conn
@@ -100,7 +624,50 @@ func (d *Dialer) Dial(ctx context.Context, peer *net.UDPAddr, introkey []byte) (
    Data <---------------------------> Data
 
 */
-func (d *Dialer) DialOverConn(ctx context.Context, udp net.Conn, introKey []byte, ip net.IP) (*Conn, error) {
+func (d *Dialer) DialOverConn(ctx context.Context, udp net.Conn, peer *net.UDPAddr, introKey []byte, identity []byte) (*Conn, error) {
+	hs, err := d.handshake(ctx, udp, peer, introKey, identity)
+	if err != nil {
+		return nil, err
+	}
+	return hs.newConn(udp, true, nil), nil
+}
+
+// handshakeResult is the outcome of a successful handshake as Alice
+type handshakeResult struct {
+	sessionKey []byte
+	macKey     []byte
+
+	// Relay tag offered by Bob, zero if none
+	relayTag uint32
+
+	// Datagrams of the Session Confirmed, to be resent until Bob answers
+	confirmed [][]byte
+
+	// Options of the session
+	opts *Dialer
+}
+
+// newConn creates the Conn of the session
+func (hs *handshakeResult) newConn(underlying net.Conn, foreign bool, control controlHandler) *Conn {
+	conn := newConn(underlying, foreign, hs.sessionKey, hs.macKey, hs.confirmed, control, hs.opts)
+	conn.relayTag = hs.relayTag
+	return conn
+}
+
+// handshake does the Alice side of the handshake over a net.Conn to a single peer
+func (d *Dialer) handshake(ctx context.Context, udp net.Conn, peer *net.UDPAddr, introKey []byte, identity []byte) (*handshakeResult, error) {
+	// The peer's identity specifies how we'll have to check its signature
+	verifier, err := identityVerifier(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bob's IP is sent in its shortest form
+	ip := shortIP(peer.IP)
+
+	// Apply the context to the connection for the duration of the handshake
+	stop := watchContext(ctx, udp)
+	defer stop()
 
 	// STEP 1: Session Request
 
@@ -120,32 +687,113 @@ func (d *Dialer) DialOverConn(ctx context.Context, udp net.Conn, introKey []byte
 	if err != nil {
 		return nil, err
 	}
-	// Embed it into a datagram
+	// Embed it into a datagram, telling Bob in its extended options if we don't need a relay tag
 	srd := &datagram{
 		Flag:    composeFlag(payloadSessionRequest, false, false),
 		Time:    uint32(time.Now().Unix()),
 		Payload: srb,
 	}
-	// Marshal the datagram
+	if d.NoRelayTag {
+		srd.Flag = composeFlag(payloadSessionRequest, false, true)
+		srd.Options, err = (&sessionRequestOptions{RelayTagRequested: false}).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Send it, using Bob's intro key
 	srdb, err := srd.MarshalBinary(introKey, introKey)
 	if err != nil {
 		return nil, err
 	}
-	// Send the message over the UDP connection
 	_, err = udp.Write(srdb)
 	if err != nil {
 		return nil, err
 	}
+	// And resend it until Bob answers, as either may be lost
+	stopResend := resend(udp, srdb)
+	defer stopResend()
 
-	// STEP 2: Receive a Session Created or wait for answer
-	//_, err := udp.Read()
-	// dh.NewPublicKey(decryptedSessionCreated.Y[:])
+	// STEP 2: Receive a Session Created
 
-	return nil, errors.New("not fully implemented")
-}
+	// It is encrypted with Bob's intro key
+	scd, err := readDatagram(ctx, udp, payloadSessionCreated, introKey, introKey)
+	if err != nil {
+		return nil, err
+	}
+	stopResend()
+	// Unmarshal it
+	sc := new(sessionCreated)
+	err = sc.UnmarshalBinary(scd.Payload)
+	if err != nil {
+		return nil, err
+	}
+	// Compute the shared secret from Y
+	shared, err := dhGroup.ComputeKey(dhkx.NewPublicKey(sc.Y[:]), priv)
+	if err != nil {
+		return nil, err
+	}
+	// Derive the session and mac keys
+	sessionKey, err := sessionKeyFromDHKey(shared.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := macKeyFromDHKey(shared.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	// Decrypt Bob's signature, which is encrypted with the session key and the datagram's IV
+	sc.SessionKey = sessionKey
+	sc.IV = scd.IV
+	bobSig, err := sc.decryptSignature(verifier.SignatureSize())
+	if err != nil {
+		return nil, err
+	}
+	// And check it
+	data := sessionSignedData(&sr.X, &sc.Y, sc.Addr.IP, uint16(sc.Addr.Port), ip, uint16(peer.Port), &sc.RelayTag, time.Unix(int64(sc.SignedOn), 0))
+	if !verifier.Verify(data, bobSig) {
+		return nil, errors.New("invalid signature in session created")
+	}
 
-// DialIndirect does an indirect dial
-// NOT IMPLEMENTED
-func (d *Dialer) DialIndirect() (*Conn, error) {
-	return nil, nil
+	// STEP 3: Session Confirmed
+
+	// Sign the same exchanged data, with our own signed-on time
+	signedOn := time.Now()
+	data = sessionSignedData(&sr.X, &sc.Y, sc.Addr.IP, uint16(sc.Addr.Port), ip, uint16(peer.Port), &sc.RelayTag, signedOn)
+	aliceSig, err := d.Signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	// Prepare the message
+	scf := &sessionConfirmed{
+		Identity:  d.RouterIdentity,
+		SignedOn:  uint32(signedOn.Unix()),
+		Signature: aliceSig,
+	}
+	// Marshal it, fragmenting our identity if necessary
+	fragments, err := scf.marshalFragments(sessionConfirmedMaxFragmentSize)
+	if err != nil {
+		return nil, err
+	}
+	// Send each fragment embedded in a datagram, using the newly established keys
+	confirmed := make([][]byte, len(fragments))
+	for i, fragment := range fragments {
+		confirmed[i], err = marshalDatagram(payloadSessionConfirmed, fragment, macKey, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		_, err = udp.Write(confirmed[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The session is established
+	hs := &handshakeResult{
+		sessionKey: sessionKey,
+		macKey:     macKey,
+		relayTag:   binary.BigEndian.Uint32(sc.RelayTag[:]),
+		confirmed:  confirmed,
+		opts:       d,
+	}
+	return hs, nil
 }
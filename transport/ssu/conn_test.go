@@ -0,0 +1,320 @@
+package ssu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestConnPair establishes a session between alice and bob over the loopback, returning both ends
+// The connections and the listener are closed at the end of the test
+func newTestConnPair(t *testing.T, bob *Dialer, alice *Dialer) (aliceConn *Conn, bobConn *Conn) {
+	// Listen
+	l, err := Listen("udp4", "127.0.0.1:0", bob)
+	if err != nil {
+		t.Fatalf("error in Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	// Accept in the background
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := l.AcceptSSU()
+		if err != nil {
+			t.Errorf("error in AcceptSSU: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	// Dial
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	aliceConn, err = alice.Dial(ctx, l.Addr().(*net.UDPAddr), bob.Introkey, bob.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in Dial: %v", err)
+	}
+	t.Cleanup(func() { aliceConn.Close() })
+
+	bobConn = <-accepted
+	if bobConn == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { bobConn.Close() })
+
+	return aliceConn, bobConn
+}
+
+// readMessage reads a message from a Conn, failing the test if none arrives in time
+func readMessage(t *testing.T, conn *Conn) []byte {
+	type result struct {
+		msg []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf := make([]byte, maxFragments*maxFragmentSize)
+		n, err := conn.Read(buf)
+		done <- result{buf[:n], err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("error in Read: %v", res.err)
+		}
+		return res.msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestConn_ReadWrite(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	var tests = []struct {
+		desc string
+		size int
+	}{
+		{"empty", 0},
+		{"single fragment", 100},
+		{"several fragments", 10 * 1024},
+		{"maximum size", maxFragments * aliceConn.maxFragmentSize()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			msg := make([]byte, test.size)
+			rand.Read(msg)
+
+			// Alice to Bob
+			if _, err := aliceConn.Write(msg); err != nil {
+				t.Fatalf("error in Write: %v", err)
+			}
+			if got := readMessage(t, bobConn); !bytes.Equal(got, msg) {
+				t.Errorf("received message differs: got %d bytes, sent %d", len(got), len(msg))
+			}
+
+			// Bob to Alice
+			if _, err := bobConn.Write(msg); err != nil {
+				t.Fatalf("error in Write: %v", err)
+			}
+			if got := readMessage(t, aliceConn); !bytes.Equal(got, msg) {
+				t.Errorf("received message differs: got %d bytes, sent %d", len(got), len(msg))
+			}
+		})
+	}
+}
+
+func TestConn_WriteTooLarge(t *testing.T) {
+	aliceConn, _ := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	msg := make([]byte, maxFragments*aliceConn.maxFragmentSize()+1)
+	if _, err := aliceConn.Write(msg); err != errMessageTooLarge {
+		t.Errorf("expected errMessageTooLarge, got %v", err)
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	aliceConn, _ := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	// A blocked Read must return once the connection is closed
+	done := make(chan error, 1)
+	go func() {
+		_, err := aliceConn.Read(make([]byte, 1))
+		done <- err
+	}()
+	aliceConn.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error from Read after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read still blocked after Close")
+	}
+
+	if _, err := aliceConn.Write([]byte("hello")); err == nil {
+		t.Error("expected an error from Write after Close")
+	}
+}
+
+func TestConn_DeliveryFailed(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	// Bob stops acknowledging, without telling Alice
+	bobConn.close(net.ErrClosed, false)
+
+	// Shorten the retransmissions
+	aliceConn.retransmitter.mu.Lock()
+	aliceConn.retransmitter.rtt.sample(time.Millisecond)
+	aliceConn.retransmitter.maxAttempts = 3
+	aliceConn.retransmitter.mu.Unlock()
+
+	if _, err := aliceConn.Write([]byte("hello")); err != ErrDeliveryFailed {
+		t.Errorf("expected ErrDeliveryFailed, got %v", err)
+	}
+}
+
+func TestConn_CongestionState(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	// Send a message larger than the initial window
+	msg := make([]byte, 2*initialWindowSegments*aliceConn.maxFragmentSize())
+	if _, err := aliceConn.Write(msg); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	readMessage(t, bobConn)
+
+	// Once acknowledged, nothing is in flight anymore and the window grew
+	state := aliceConn.CongestionState()
+	if state.InFlight != 0 {
+		t.Errorf("expected nothing in flight, got %d bytes", state.InFlight)
+	}
+	if state.Window <= initialWindowSegments*(fragmentHeaderLen+aliceConn.maxFragmentSize()) {
+		t.Errorf("window didn't grow: %+v", state)
+	}
+	if state.RTT == 0 {
+		t.Errorf("RTT wasn't sampled: %+v", state)
+	}
+}
+
+func TestConn_SessionDestroyed(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+
+	// Bob waits for a message
+	done := make(chan error, 1)
+	go func() {
+		_, err := bobConn.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	// Alice closes the session, which tears down Bob's side too
+	aliceConn.Close()
+	select {
+	case err := <-done:
+		if err != ErrSessionDestroyed {
+			t.Errorf("expected ErrSessionDestroyed from Read, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read still blocked after the session was destroyed")
+	}
+	if _, err := bobConn.Write([]byte("hello")); err != ErrSessionDestroyed {
+		t.Errorf("expected ErrSessionDestroyed from Write, got %v", err)
+	}
+}
+
+func TestConn_SessionDestroyedIntroKey(t *testing.T) {
+	alice, bob := newTestDialer(t), newTestDialer(t)
+	aliceConn, bobConn := newTestConnPair(t, bob, alice)
+
+	// Session destroyed messages keyed with either intro key are ignored
+	for _, key := range [][]byte{alice.Introkey, bob.Introkey} {
+		if err := writeDatagram(aliceConn.underlying, payloadSessionDestroyed, nil, key, key); err != nil {
+			t.Fatalf("error in writeDatagram: %v", err)
+		}
+	}
+
+	// So the session still works
+	if _, err := aliceConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	if got := readMessage(t, bobConn); string(got) != "hello" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestConn_Deadlines(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+	buf := make([]byte, 16)
+
+	// A pending Read times out
+	if err := aliceConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("error in SetReadDeadline: %v", err)
+	}
+	_, err := aliceConn.Read(buf)
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// Until the deadline is reset
+	if err := aliceConn.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatalf("error in SetReadDeadline: %v", err)
+	}
+	if _, err := bobConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	if got := readMessage(t, aliceConn); string(got) != "hello" {
+		t.Errorf("got %q", got)
+	}
+
+	// A pending Write times out too, if Bob doesn't acknowledge
+	bobConn.close(net.ErrClosed, false)
+	if err := aliceConn.SetDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("error in SetDeadline: %v", err)
+	}
+	n, err := aliceConn.Write([]byte("hello"))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || n != 0 {
+		t.Fatalf("expected a timeout, got %d, %v", n, err)
+	}
+
+	// And the following ones fail at once
+	start := time.Now()
+	if _, err := aliceConn.Write([]byte("hello")); err != os.ErrDeadlineExceeded || time.Since(start) > time.Second {
+		t.Errorf("expected an immediate timeout, got %v", err)
+	}
+	if _, err := aliceConn.Read(buf); err != os.ErrDeadlineExceeded {
+		t.Errorf("expected an immediate timeout, got %v", err)
+	}
+}
+
+func TestConn_CloseForeign(t *testing.T) {
+	bob := newTestDialer(t)
+	l, err := Listen("udp4", "127.0.0.1:0", bob)
+	if err != nil {
+		t.Fatalf("error in Listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	// Dial over our own socket
+	raddr := l.Addr().(*net.UDPAddr)
+	udp, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		t.Fatalf("error in DialUDP: %v", err)
+	}
+	defer udp.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := newTestDialer(t).DialOverConn(ctx, udp, raddr, bob.Introkey, bob.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in DialOverConn: %v", err)
+	}
+	conn.Close()
+
+	// Reading the socket blocks rather than failing at once
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, maximumDatagramSize)
+		for {
+			if _, err := udp.Read(buf); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		t.Errorf("error reading the socket after Close: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,438 @@
+package ssu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// Data message flags
+	dataFlagExplicitACKs        = 1 << 7
+	dataFlagACKBitfields        = 1 << 6
+	dataFlagECN                 = 1 << 4
+	dataFlagRequestPreviousACKs = 1 << 3
+	dataFlagWantReply           = 1 << 2
+	dataFlagExtendedData        = 1 << 1
+
+	// maxFragmentNum is the highest fragment number representable in the fragment info
+	maxFragmentNum = 1<<7 - 1
+
+	// maxFragmentSize is the largest fragment size representable in the fragment info
+	maxFragmentSize = 1<<14 - 1
+
+	// fragmentHeaderLen is the length of the message ID and fragment info preceding each fragment's data
+	fragmentHeaderLen = 4 + 3
+
+	// maxDataListLen is the maximum number of explicit ACKs, ACK bitfields or fragments, as they are counted in a single byte
+	maxDataListLen = 1<<8 - 1
+)
+
+/*
+A dataMessage is the payload of a Data (type 6) datagram, used for data transport and acknowledgment
+
++----+----+----+----+----+----+----+----+
+|flag| (additional headers, determined  |
++----+                                  +
+~ by the flags, such as ACKs or         ~
+| bitfields                             |
++----+----+----+----+----+----+----+----+
+|#frg|     messageId     |   frag info  |
++----+----+----+----+----+----+----+----+
+| that many bytes of fragment data      |
+~                .  .  .                ~
+|                                       |
++----+----+----+----+----+----+----+----+
+|     messageId     |   frag info  |    |
++----+----+----+----+----+----+----+    +
+| that many bytes of fragment data      |
+~                .  .  .                ~
+|                                       |
++----+----+----+----+----+----+----+----+
+| arbitrary amount of uninterpreted data|
+~                .  .  .                ~
+
+The flags are:
+
+  Bit order: 76543210 (bit 7 is MSB)
+  bit 7: explicit ACKs included
+  bit 6: ACK bitfields included
+  bit 5: reserved
+  bit 4: explicit congestion notification (ECN)
+  bit 3: request previous ACKs
+  bit 2: want reply
+  bit 1: extended data included (unused, never set)
+  bit 0: reserved
+
+The presence flags (explicit ACKs, ACK bitfields, extended data) are derived from the content of the message
+*/
+type dataMessage struct {
+	// Flags which aren't derived from the content
+	ECN                 bool
+	RequestPreviousACKs bool
+	WantReply           bool
+
+	// Message IDs being fully ACKed
+	ACKs []uint32
+
+	// Message IDs being partially ACKed
+	ACKBitfields []ackBitfield
+
+	// Extended data, currently uninterpreted
+	ExtendedData []byte
+
+	// Message fragments
+	Fragments []dataFragment
+}
+
+/*
+An ackBitfield partially acknowledges a message
+It is marshalled as a message ID followed by 1 or more bitfield bytes. The bitfield uses the 7 low bits of each byte,
+with the high bit specifying whether an additional bitfield byte follows it (1 = true, 0 = the current bitfield byte
+is the last). To clarify, assuming fragments 0, 2, 5, and 9 have been received, the bitfield bytes would be as
+follows:
+
+  byte 0:
+    bit 7: 1 (further bitfield bytes follow)
+    bit 6: 0 (fragment 6 not received)
+    bit 5: 1 (fragment 5 received)
+    bit 4: 0 (fragment 4 not received)
+    bit 3: 0 (fragment 3 not received)
+    bit 2: 1 (fragment 2 received)
+    bit 1: 0 (fragment 1 not received)
+    bit 0: 1 (fragment 0 received)
+  byte 1:
+    bit 7: 0 (no further bitfield bytes)
+    bit 6: 0 (fragment 13 not received)
+    bit 5: 0 (fragment 12 not received)
+    bit 4: 0 (fragment 11 not received)
+    bit 3: 0 (fragment 10 not received)
+    bit 2: 1 (fragment 9 received)
+    bit 1: 0 (fragment 8 not received)
+    bit 0: 0 (fragment 7 not received)
+*/
+type ackBitfield struct {
+	MessageID uint32
+	Received  fragmentSet
+}
+
+// fragmentSet is a set of fragment numbers, from 0 to maxFragmentNum
+type fragmentSet [2]uint64
+
+// add adds the fragment number to the set
+func (fs *fragmentSet) add(num byte) { fs[num/64&1] |= 1 << (num % 64) }
+
+// has checks whether the fragment number is in the set
+func (fs *fragmentSet) has(num byte) bool { return fs[num/64&1]&(1<<(num%64)) != 0 }
+
+// len returns the number of fragments in the set
+func (fs *fragmentSet) len() int { return bits.OnesCount64(fs[0]) + bits.OnesCount64(fs[1]) }
+
+// highest returns the highest fragment number in the set, or -1 if it is empty
+func (fs *fragmentSet) highest() int {
+	if fs[1] != 0 {
+		return 127 - bits.LeadingZeros64(fs[1])
+	}
+	return 63 - bits.LeadingZeros64(fs[0])
+}
+
+// bitfieldLen returns the number of bitfield bytes needed to represent the set, which is at least one
+func (fs *fragmentSet) bitfieldLen() int {
+	return fs.highest()/7 + 1
+}
+
+/*
+A dataFragment is a fragment of a message
+
++----+----+----+----+----+----+----+----+
+|     messageId     |   frag info  |    |
++----+----+----+----+----+----+----+    +
+| that many bytes of fragment data      |
+~                .  .  .                ~
+
+The fragment info is:
+
+  Bit order: 76543210 (bit 7 is MSB)
+  bits 23-17: fragment # 0 - 127
+  bit 16: isLast (1 = true)
+  bits 15-14: unused, set to 0 for compatibility with future uses
+  bits 13-0: fragment size 0 - 16383
+*/
+type dataFragment struct {
+	MessageID uint32
+	Num       byte
+	IsLast    bool
+	Data      []byte
+}
+
+// flag returns the flags byte of the message
+func (dm *dataMessage) flag() byte {
+	var flag byte
+	if len(dm.ACKs) != 0 {
+		flag |= dataFlagExplicitACKs
+	}
+	if len(dm.ACKBitfields) != 0 {
+		flag |= dataFlagACKBitfields
+	}
+	if dm.ECN {
+		flag |= dataFlagECN
+	}
+	if dm.RequestPreviousACKs {
+		flag |= dataFlagRequestPreviousACKs
+	}
+	if dm.WantReply {
+		flag |= dataFlagWantReply
+	}
+	if len(dm.ExtendedData) != 0 {
+		flag |= dataFlagExtendedData
+	}
+	return flag
+}
+
+// marshalledLen returns the length of the marshalled message, without padding
+func (dm *dataMessage) marshalledLen() int {
+	// Flag & number of fragments
+	n := 1 + 1
+
+	// Explicit ACKs
+	if len(dm.ACKs) != 0 {
+		n += 1 + 4*len(dm.ACKs)
+	}
+
+	// ACK Bitfields
+	if len(dm.ACKBitfields) != 0 {
+		n++
+		for _, bf := range dm.ACKBitfields {
+			n += 4 + bf.Received.bitfieldLen()
+		}
+	}
+
+	// Extended data
+	if len(dm.ExtendedData) != 0 {
+		n += 1 + len(dm.ExtendedData)
+	}
+
+	// Fragments
+	for _, f := range dm.Fragments {
+		n += fragmentHeaderLen + len(f.Data)
+	}
+
+	return n
+}
+
+// MarshalBinary marshals a dataMessage to binary form, without padding as it is added by the datagram
+func (dm *dataMessage) MarshalBinary() ([]byte, error) {
+	// Sanity check
+	if len(dm.ACKs) > maxDataListLen {
+		return nil, fmt.Errorf("too many explicit ACKs: %d", len(dm.ACKs))
+	} else if len(dm.ACKBitfields) > maxDataListLen {
+		return nil, fmt.Errorf("too many ACK bitfields: %d", len(dm.ACKBitfields))
+	} else if len(dm.ExtendedData) > maxDataListLen {
+		return nil, fmt.Errorf("extended data too large: %d", len(dm.ExtendedData))
+	} else if len(dm.Fragments) > maxDataListLen {
+		return nil, fmt.Errorf("too many fragments: %d", len(dm.Fragments))
+	}
+	for _, f := range dm.Fragments {
+		if f.Num > maxFragmentNum {
+			return nil, fmt.Errorf("fragment number %d overflows 7 bits", f.Num)
+		} else if len(f.Data) > maxFragmentSize {
+			return nil, fmt.Errorf("fragment size %d overflows 14 bits", len(f.Data))
+		}
+	}
+
+	// Create the slice
+	b := make([]byte, dm.marshalledLen())
+
+	// Write the flag
+	b[0] = dm.flag()
+	cursor := 1
+
+	// Write the explicit ACKs
+	if len(dm.ACKs) != 0 {
+		b[cursor] = byte(len(dm.ACKs))
+		cursor++
+		for _, id := range dm.ACKs {
+			binary.BigEndian.PutUint32(b[cursor:cursor+4], id)
+			cursor += 4
+		}
+	}
+
+	// Write the ACK bitfields
+	if len(dm.ACKBitfields) != 0 {
+		b[cursor] = byte(len(dm.ACKBitfields))
+		cursor++
+		for _, bf := range dm.ACKBitfields {
+			binary.BigEndian.PutUint32(b[cursor:cursor+4], bf.MessageID)
+			cursor += 4
+
+			// Each byte carries 7 fragments, the high bit indicating whether another byte follows
+			n := bf.Received.bitfieldLen()
+			for i := 0; i < n; i++ {
+				var bfb byte
+				for j := 0; j < 7; j++ {
+					if num := i*7 + j; num <= maxFragmentNum && bf.Received.has(byte(num)) {
+						bfb |= 1 << uint(j)
+					}
+				}
+				if i != n-1 {
+					bfb |= 1 << 7
+				}
+				b[cursor] = bfb
+				cursor++
+			}
+		}
+	}
+
+	// Write the extended data
+	if len(dm.ExtendedData) != 0 {
+		b[cursor] = byte(len(dm.ExtendedData))
+		cursor++
+		cursor += copy(b[cursor:], dm.ExtendedData)
+	}
+
+	// Write the fragments
+	b[cursor] = byte(len(dm.Fragments))
+	cursor++
+	for _, f := range dm.Fragments {
+		// Message ID
+		binary.BigEndian.PutUint32(b[cursor:cursor+4], f.MessageID)
+		cursor += 4
+
+		// Fragment info
+		info := uint32(f.Num)<<17 | uint32(len(f.Data))
+		if f.IsLast {
+			info |= 1 << 16
+		}
+		b[cursor] = byte(info >> 16)
+		b[cursor+1] = byte(info >> 8)
+		b[cursor+2] = byte(info)
+		cursor += 3
+
+		// Data
+		cursor += copy(b[cursor:], f.Data)
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary unmarshals a dataMessage from its binary form, ignoring any trailing padding
+// Does not retain b
+func (dm *dataMessage) UnmarshalBinary(b []byte) error {
+	errTooSmall := errors.New("data message is invalid: too small")
+	if len(b) < 1 {
+		return errTooSmall
+	}
+
+	// Read the flag
+	flag := b[0]
+	dm.ECN = flag&dataFlagECN != 0
+	dm.RequestPreviousACKs = flag&dataFlagRequestPreviousACKs != 0
+	dm.WantReply = flag&dataFlagWantReply != 0
+	cursor := 1
+
+	// Read the explicit ACKs
+	dm.ACKs = nil
+	if flag&dataFlagExplicitACKs != 0 {
+		if len(b) < cursor+1 {
+			return errTooSmall
+		}
+		n := int(b[cursor])
+		cursor++
+		if len(b) < cursor+4*n {
+			return errTooSmall
+		}
+		dm.ACKs = make([]uint32, n)
+		for i := range dm.ACKs {
+			dm.ACKs[i] = binary.BigEndian.Uint32(b[cursor : cursor+4])
+			cursor += 4
+		}
+	}
+
+	// Read the ACK bitfields
+	dm.ACKBitfields = nil
+	if flag&dataFlagACKBitfields != 0 {
+		if len(b) < cursor+1 {
+			return errTooSmall
+		}
+		n := int(b[cursor])
+		cursor++
+		dm.ACKBitfields = make([]ackBitfield, n)
+		for i := range dm.ACKBitfields {
+			if len(b) < cursor+4 {
+				return errTooSmall
+			}
+			dm.ACKBitfields[i].MessageID = binary.BigEndian.Uint32(b[cursor : cursor+4])
+			cursor += 4
+
+			// Read bitfield bytes until one doesn't have its high bit set
+			for j := 0; ; j++ {
+				if len(b) < cursor+1 {
+					return errTooSmall
+				}
+				bfb := b[cursor]
+				cursor++
+				for k := 0; k < 7; k++ {
+					if num := j*7 + k; num <= maxFragmentNum && bfb&(1<<uint(k)) != 0 {
+						dm.ACKBitfields[i].Received.add(byte(num))
+					}
+				}
+				if bfb&(1<<7) == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	// Read the extended data
+	dm.ExtendedData = nil
+	if flag&dataFlagExtendedData != 0 {
+		if len(b) < cursor+1 {
+			return errTooSmall
+		}
+		n := int(b[cursor])
+		cursor++
+		if len(b) < cursor+n {
+			return errTooSmall
+		}
+		dm.ExtendedData = make([]byte, n)
+		cursor += copy(dm.ExtendedData, b[cursor:cursor+n])
+	}
+
+	// Read the fragments
+	if len(b) < cursor+1 {
+		return errTooSmall
+	}
+	n := int(b[cursor])
+	cursor++
+	dm.Fragments = nil
+	if n != 0 {
+		dm.Fragments = make([]dataFragment, n)
+	}
+	for i := range dm.Fragments {
+		if len(b) < cursor+fragmentHeaderLen {
+			return errTooSmall
+		}
+
+		// Message ID
+		dm.Fragments[i].MessageID = binary.BigEndian.Uint32(b[cursor : cursor+4])
+		cursor += 4
+
+		// Fragment info
+		info := uint32(b[cursor])<<16 | uint32(b[cursor+1])<<8 | uint32(b[cursor+2])
+		cursor += 3
+		dm.Fragments[i].Num = byte(info >> 17)
+		dm.Fragments[i].IsLast = info&(1<<16) != 0
+		size := int(info & maxFragmentSize)
+
+		// Data
+		if len(b) < cursor+size {
+			return errTooSmall
+		}
+		dm.Fragments[i].Data = make([]byte, size)
+		cursor += copy(dm.Fragments[i].Data, b[cursor:cursor+size])
+	}
+
+	// What remains is padding
+	return nil
+}
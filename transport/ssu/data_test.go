@@ -0,0 +1,208 @@
+package ssu
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// newFragmentSet creates a fragmentSet holding the given fragment numbers
+func newFragmentSet(nums ...byte) fragmentSet {
+	var fs fragmentSet
+	for _, num := range nums {
+		fs.add(num)
+	}
+	return fs
+}
+
+// TestDataMessage_Marshal tests the binary form of data messages against hand-made expectations, field by field
+func TestDataMessage_Marshal(t *testing.T) {
+	var tests = []struct {
+		name     string
+		dm       *dataMessage
+		expected []byte
+	}{
+		{
+			name:     "empty",
+			dm:       &dataMessage{},
+			expected: []byte{0x00, 0x00},
+		},
+		{
+			name:     "flags",
+			dm:       &dataMessage{ECN: true, RequestPreviousACKs: true, WantReply: true},
+			expected: []byte{dataFlagECN | dataFlagRequestPreviousACKs | dataFlagWantReply, 0x00},
+		},
+		{
+			name: "explicit ACKs",
+			dm:   &dataMessage{ACKs: []uint32{0x01020304, 0xA0B0C0D0}},
+			expected: []byte{
+				dataFlagExplicitACKs,
+				2, 0x01, 0x02, 0x03, 0x04, 0xA0, 0xB0, 0xC0, 0xD0,
+				0x00,
+			},
+		},
+		{
+			name: "ACK bitfield from the specification",
+			dm:   &dataMessage{ACKBitfields: []ackBitfield{{MessageID: 0x01020304, Received: newFragmentSet(0, 2, 5, 9)}}},
+			expected: []byte{
+				dataFlagACKBitfields,
+				1, 0x01, 0x02, 0x03, 0x04, 0xA5, 0x04,
+				0x00,
+			},
+		},
+		{
+			name: "ACK bitfields of various lengths",
+			dm: &dataMessage{ACKBitfields: []ackBitfield{
+				{MessageID: 1, Received: newFragmentSet()},
+				{MessageID: 2, Received: newFragmentSet(6)},
+				{MessageID: 3, Received: newFragmentSet(7)},
+				{MessageID: 4, Received: newFragmentSet(63)},
+			}},
+			expected: []byte{
+				dataFlagACKBitfields,
+				4,
+				0, 0, 0, 1, 0x00,
+				0, 0, 0, 2, 0x40,
+				0, 0, 0, 3, 0x80, 0x01,
+				0, 0, 0, 4, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01,
+				0x00,
+			},
+		},
+		{
+			name: "extended data",
+			dm:   &dataMessage{ExtendedData: []byte{0xAA, 0xBB}},
+			expected: []byte{
+				dataFlagExtendedData,
+				2, 0xAA, 0xBB,
+				0x00,
+			},
+		},
+		{
+			name: "fragments",
+			dm: &dataMessage{Fragments: []dataFragment{
+				{MessageID: 0x01020304, Num: 0, IsLast: false, Data: []byte("abc")},
+				{MessageID: 0x01020304, Num: 127, IsLast: true, Data: []byte("d")},
+				{MessageID: 0x05060708, Num: 1, IsLast: true, Data: nil},
+			}},
+			expected: []byte{
+				0x00,
+				3,
+				0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x03, 'a', 'b', 'c',
+				0x01, 0x02, 0x03, 0x04, 0xFF, 0x00, 0x01, 'd',
+				0x05, 0x06, 0x07, 0x08, 0x03, 0x00, 0x00,
+			},
+		},
+		{
+			name: "everything",
+			dm: &dataMessage{
+				WantReply:    true,
+				ACKs:         []uint32{1},
+				ACKBitfields: []ackBitfield{{MessageID: 2, Received: newFragmentSet(1)}},
+				Fragments:    []dataFragment{{MessageID: 3, Num: 2, Data: []byte("x")}},
+			},
+			expected: []byte{
+				dataFlagExplicitACKs | dataFlagACKBitfields | dataFlagWantReply,
+				1, 0, 0, 0, 1,
+				1, 0, 0, 0, 2, 0x02,
+				1,
+				0, 0, 0, 3, 0x04, 0x00, 0x01, 'x',
+			},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := test.dm.MarshalBinary()
+		if err != nil {
+			t.Errorf("[%s] error in MarshalBinary: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(b, test.expected) {
+			t.Errorf("[%s] marshalled to %x instead of %x", test.name, b, test.expected)
+		}
+		if len(b) != test.dm.marshalledLen() {
+			t.Errorf("[%s] marshalled len %d differs from marshalledLen %d", test.name, len(b), test.dm.marshalledLen())
+		}
+
+		// Unmarshal it back, with some padding
+		dm := new(dataMessage)
+		if err := dm.UnmarshalBinary(append(b, 0xDE, 0xAD)); err != nil {
+			t.Errorf("[%s] error in UnmarshalBinary: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(dm.ACKs, test.dm.ACKs) || !reflect.DeepEqual(dm.ACKBitfields, test.dm.ACKBitfields) ||
+			!bytes.Equal(dm.ExtendedData, test.dm.ExtendedData) || dm.flag() != test.dm.flag() || len(dm.Fragments) != len(test.dm.Fragments) {
+			t.Errorf("[%s] unmarshalled to %+v instead of %+v", test.name, dm, test.dm)
+			continue
+		}
+		for i, f := range dm.Fragments {
+			o := test.dm.Fragments[i]
+			if f.MessageID != o.MessageID || f.Num != o.Num || f.IsLast != o.IsLast || !bytes.Equal(f.Data, o.Data) {
+				t.Errorf("[%s] fragment %d unmarshalled to %+v instead of %+v", test.name, i, f, o)
+			}
+		}
+	}
+}
+
+// TestDataMessage_MarshalInvalid tests that data messages whose fields cannot be represented are rejected
+func TestDataMessage_MarshalInvalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		dm   *dataMessage
+	}{
+		{"too many ACKs", &dataMessage{ACKs: make([]uint32, maxDataListLen+1)}},
+		{"too many ACK bitfields", &dataMessage{ACKBitfields: make([]ackBitfield, maxDataListLen+1)}},
+		{"extended data too large", &dataMessage{ExtendedData: make([]byte, maxDataListLen+1)}},
+		{"too many fragments", &dataMessage{Fragments: make([]dataFragment, maxDataListLen+1)}},
+		{"fragment number too high", &dataMessage{Fragments: []dataFragment{{Num: maxFragmentNum + 1}}}},
+		{"fragment too large", &dataMessage{Fragments: []dataFragment{{Data: make([]byte, maxFragmentSize+1)}}}},
+	}
+
+	for _, test := range tests {
+		if _, err := test.dm.MarshalBinary(); err == nil {
+			t.Errorf("[%s] MarshalBinary succeeded", test.name)
+		}
+	}
+}
+
+// TestDataMessage_UnmarshalTruncated tests that every truncation of a data message is rejected
+func TestDataMessage_UnmarshalTruncated(t *testing.T) {
+	dm := &dataMessage{
+		ACKs:         []uint32{1},
+		ACKBitfields: []ackBitfield{{MessageID: 2, Received: newFragmentSet(1, 8)}},
+		ExtendedData: []byte{0xAA},
+		Fragments:    []dataFragment{{MessageID: 3, Num: 2, Data: []byte("xyz")}},
+	}
+	b, err := dm.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+
+	for i := 0; i < len(b); i++ {
+		if err := new(dataMessage).UnmarshalBinary(b[:i]); err == nil {
+			t.Errorf("truncation to %d bytes accepted", i)
+		}
+	}
+}
+
+// TestFragmentSet tests the fragmentSet operations over its whole range
+func TestFragmentSet(t *testing.T) {
+	var fs fragmentSet
+	if fs.highest() != -1 || fs.len() != 0 || fs.bitfieldLen() != 1 {
+		t.Errorf("empty set: highest %d, len %d, bitfieldLen %d", fs.highest(), fs.len(), fs.bitfieldLen())
+	}
+	for num := 0; num <= maxFragmentNum; num++ {
+		fs.add(byte(num))
+		if !fs.has(byte(num)) {
+			t.Errorf("fragment %d not in set after add", num)
+		}
+		if fs.highest() != num {
+			t.Errorf("highest is %d instead of %d", fs.highest(), num)
+		}
+		if fs.len() != num+1 {
+			t.Errorf("len is %d instead of %d", fs.len(), num+1)
+		}
+		if fs.bitfieldLen() != num/7+1 {
+			t.Errorf("bitfieldLen is %d instead of %d", fs.bitfieldLen(), num/7+1)
+		}
+	}
+}
@@ -12,9 +12,12 @@ import (
 	"sync"
 )
 
+// datagramBufferPool holds buffers for marshalling the datagrams we send
+// It stores pointers to slices, so that putting them back doesn't allocate
 var datagramBufferPool = &sync.Pool{
 	New: func() interface{} {
-		return make([]byte, 0, maximumDatagramSize)
+		b := make([]byte, 0, maximumDatagramSize)
+		return &b
 	},
 }
 
@@ -27,6 +30,12 @@ const (
 
 	maximumDatagramSize = maximumPayloadSize + nominalHeaderLen
 
+	// keyingMaterialSize is the size of the keying material included in the header when rekeying
+	keyingMaterialSize = 64
+
+	// maxOptionsSize is the size of the largest extended options, as their size is a single byte
+	maxOptionsSize = 1<<8 - 1
+
 	// Cursors for datagram marshalling
 	macPos     = 0
 	ivPos      = 16
@@ -69,46 +78,105 @@ type datagram struct {
 	Flag byte
 	Time uint32 // Seconds since the UNIX epoch
 
+	// IV of the datagram, filled in by unmarshal
+	// When marshalling, a random one is generated unless it is already set
+	IV []byte
+
+	// Keying material, present if and only if the rekey flag is set
+	KeyingMaterial []byte
+
+	// Extended options, present if and only if the extended options flag is set
+	// Their format is specific to the payload type
+	Options []byte
+
+	// Size of the marshalled datagram, or zero to pad it to the next 16-byte boundary only
+	// Since release 0.9.7, a datagram may be padded to any length, the bytes beyond its last 16-byte block being left
+	// unencrypted. When marshalling, it is raised to that minimal length if it is shorter.
+	Size int
+
 	// Payload
 	Payload []byte
 }
 
 // MarshalBinary marshals a given SSU datagram to binary
 func (d *datagram) MarshalBinary(macKey, cryptoKey []byte) ([]byte, error) {
-	// We create the slice. We should use a buffer pool along with an unexported encode(dst []byte) function
+	// We create the slice. Senders on the hot path use datagramBufferPool along with MarshalBinaryTo instead
 	b := make([]byte, d.outputLen())
 
 	// Return it
 	return b, d.MarshalBinaryTo(b, macKey, cryptoKey)
 }
 
-// PadLen returns the padding length necessary
-func (d *datagram) padLen() int {
-	padLen := 16 - (1 + 4 + len(d.Payload)%16)
-	if padLen == 16 {
-		padLen = 0
+// headerLen returns the length of the header, which includes the keying material when rekeying and the extended options
+func (d *datagram) headerLen() int {
+	n := nominalHeaderLen
+	_, rekey, extended := decomposeFlag(d.Flag)
+	if rekey {
+		n += keyingMaterialSize
 	}
-	return padLen
+	if extended {
+		n += 1 + len(d.Options)
+	}
+	return n
+}
+
+// minLen returns the length of the datagram padded to the next 16-byte boundary, as required by AES
+func (d *datagram) minLen() int {
+	n := d.headerLen() + len(d.Payload)
+	return n + (16-(n-flagPos)%16)%16
 }
 
 // outputLen returns the length of the output
-func (d *datagram) outputLen() int { return nominalHeaderLen + len(d.Payload) + d.padLen() }
+func (d *datagram) outputLen() int {
+	if n := d.minLen(); d.Size < n {
+		return n
+	}
+	return d.Size
+}
+
+// PadLen returns the padding length necessary
+func (d *datagram) padLen() int { return d.outputLen() - d.headerLen() - len(d.Payload) }
 
 // MarshalBinaryTo marshals a datagram to a given slice of bytes, with the correct length
 // If the slice is not large enough, it errors
 func (d *datagram) MarshalBinaryTo(b []byte, macKey []byte, cryptoKey []byte) error {
+	// Check the slice length
+	if len(b) != d.outputLen() {
+		return fmt.Errorf("invalid slice length for datagram: %d instead of %d", len(b), d.outputLen())
+	}
+
 	// Copy the flag
 	b[flagPos] = d.Flag
 
 	// Copy the time
 	binary.BigEndian.PutUint32(b[timePos:payloadPos], d.Time)
 
+	// Copy the keying material
+	pos := payloadPos
+	_, rekey, extended := decomposeFlag(d.Flag)
+	if rekey {
+		if len(d.KeyingMaterial) != keyingMaterialSize {
+			return fmt.Errorf("invalid keying material length: %d instead of %d", len(d.KeyingMaterial), keyingMaterialSize)
+		}
+		pos += copy(b[pos:], d.KeyingMaterial)
+	}
+
+	// Copy the extended options, preceded by their size
+	if extended {
+		if len(d.Options) > maxOptionsSize {
+			return errors.New("extended options too large")
+		}
+		b[pos] = byte(len(d.Options))
+		copy(b[pos+1:], d.Options)
+	}
+
 	// Copy the payload
-	copy(b[payloadPos:], d.Payload)
+	headerLen := d.headerLen()
+	copy(b[headerLen:], d.Payload)
 
 	// Write random padding if necessary
 	if padLen := d.padLen(); padLen != 0 {
-		n, err := rand.Read(b[payloadPos+len(d.Payload):])
+		n, err := rand.Read(b[headerLen+len(d.Payload):])
 		if err != nil {
 			return err
 		}
@@ -117,10 +185,11 @@ func (d *datagram) MarshalBinaryTo(b []byte, macKey []byte, cryptoKey []byte) er
 		}
 	}
 
-	// Now we generate the IV (we need it for the MAC)
+	// Now we generate the IV (we need it for the MAC), unless it is given
 	// Note: rand.Read calls ReadFull, so we don't need to check for the number of bytes read
-	_, err := rand.Read(b[ivPos:flagPos])
-	if err != nil {
+	if len(d.IV) == flagPos-ivPos {
+		copy(b[ivPos:flagPos], d.IV)
+	} else if _, err := rand.Read(b[ivPos:flagPos]); err != nil {
 		return err
 	}
 
@@ -134,7 +203,9 @@ func (d *datagram) MarshalBinaryTo(b []byte, macKey []byte, cryptoKey []byte) er
 	enc := cipher.NewCBCEncrypter(c, b[16:32])
 
 	// Let's encrypt the flag, time, payload & padding, in-place
-	enc.CryptBlocks(b[flagPos:], b[flagPos:])
+	// Any padding beyond the last block of 16 bytes is left as is
+	encrypted := b[flagPos : flagPos+(len(b)-flagPos)/16*16]
+	enc.CryptBlocks(encrypted, encrypted)
 
 	// Generate the MAC
 	mac, err := createDatagramHMAC(b[flagPos:], b[ivPos:flagPos], len(b)-flagPos, macKey)
@@ -185,8 +256,25 @@ func createDatagramHMAC(encPayload []byte, iv []byte, payloadLen int, macKey []b
 	return hasher.Sum(nil), nil
 }
 
+// authenticDatagram checks the MAC of a datagram with the given key, without decrypting it
+func authenticDatagram(b []byte, macKey []byte) bool {
+	if len(b) < flagPos+16 {
+		return false
+	}
+	calcMAC, err := createDatagramHMAC(b[flagPos:], b[ivPos:flagPos], len(b)-flagPos, macKey)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(b[:ivPos], calcMAC)
+}
+
 // Does not retain b
 func (d *datagram) unmarshal(b []byte, macKey []byte, decKey []byte) error {
+	// Check for correct minimum len
+	if len(b) < flagPos+16 {
+		return errors.New("datagram is invalid: too small")
+	}
+
 	// First we'll store the values we'll be using the decrypt and unmarshal the message
 	var (
 		mac       = b[:ivPos]
@@ -214,16 +302,42 @@ func (d *datagram) unmarshal(b []byte, macKey []byte, decKey []byte) error {
 	dec := cipher.NewCBCDecrypter(c, iv)
 
 	// Let's decrypt the data
-	tmp := make([]byte, len(b)-flagPos)
-	dec.CryptBlocks(tmp, b[flagPos:])
+	// Any extra bytes beyond the last block of 16 bytes cannot be decrypted and are ignored
+	tmp := make([]byte, (len(b)-flagPos)/16*16)
+	dec.CryptBlocks(tmp, b[flagPos:flagPos+len(tmp)])
+
+	// Keep the IV
+	d.IV = make([]byte, flagPos-ivPos)
+	copy(d.IV, iv)
 
-	// Split it into flag, time and payload
+	// Split it into flag, time, keying material, extended options and payload
 	d.Flag = tmp[0]
 	d.Time = binary.BigEndian.Uint32(tmp[1:5])
-	if len(d.Payload) < len(tmp)-5 {
-		d.Payload = make([]byte, len(tmp)-5)
+	tmp = tmp[5:]
+	_, rekey, extended := decomposeFlag(d.Flag)
+	d.KeyingMaterial = nil
+	if rekey {
+		if len(tmp) < keyingMaterialSize {
+			return errors.New("datagram is invalid: too small for its keying material")
+		}
+		d.KeyingMaterial = make([]byte, keyingMaterialSize)
+		copy(d.KeyingMaterial, tmp)
+		tmp = tmp[keyingMaterialSize:]
+	}
+	d.Options = nil
+	if extended {
+		if len(tmp) < 1 || len(tmp) < 1+int(tmp[0]) {
+			return errors.New("datagram is invalid: too small for its extended options")
+		}
+		d.Options = make([]byte, tmp[0])
+		copy(d.Options, tmp[1:])
+		tmp = tmp[1+len(d.Options):]
+	}
+	if cap(d.Payload) < len(tmp) {
+		d.Payload = make([]byte, len(tmp))
 	}
-	copy(d.Payload, tmp[5:])
+	d.Payload = d.Payload[:len(tmp)]
+	copy(d.Payload, tmp)
 
 	// Return
 	return nil
@@ -1,6 +1,7 @@
 package ssu
 
 import (
+	"bytes"
 	"testing"
 	"time"
 )
@@ -38,3 +39,92 @@ func TestDatagram_MarshallingCoherence(t *testing.T) {
 	t.Logf("decrypted payload: %v", destination)
 
 }
+
+func TestDatagram_KeyingMaterial(t *testing.T) {
+	key := make([]byte, sessionKeySize)
+	material := make([]byte, keyingMaterialSize)
+	for i := range material {
+		material[i] = byte(i)
+	}
+
+	origin := &datagram{
+		Flag:           composeFlag(payloadData, true, false),
+		Time:           uint32(time.Now().Unix()),
+		KeyingMaterial: material,
+		Payload:        []byte("this is the payload"),
+	}
+	b, err := origin.MarshalBinary(key, key)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	if len(b)%16 != 0 || len(b) < nominalHeaderLen+keyingMaterialSize+len(origin.Payload) {
+		t.Errorf("unexpected length %d", len(b))
+	}
+
+	destination := new(datagram)
+	if err := destination.unmarshal(b, key, key); err != nil {
+		t.Fatalf("error in unmarshal: %v", err)
+	}
+	if !bytes.Equal(destination.KeyingMaterial, material) {
+		t.Errorf("keying material %v, expected %v", destination.KeyingMaterial, material)
+	}
+	if !bytes.HasPrefix(destination.Payload, origin.Payload) {
+		t.Errorf("payload %q, expected %q", destination.Payload, origin.Payload)
+	}
+
+	// The rekey flag requires the material
+	origin.KeyingMaterial = material[:32]
+	if _, err := origin.MarshalBinary(key, key); err == nil {
+		t.Error("no error when marshalling truncated keying material")
+	}
+}
+
+func TestDatagram_Options(t *testing.T) {
+	key := make([]byte, sessionKeySize)
+	material := make([]byte, keyingMaterialSize)
+
+	var tests = []struct {
+		desc  string
+		rekey bool
+		opts  []byte
+	}{
+		{"empty options", false, []byte{}},
+		{"options", false, []byte{1, 2, 3}},
+		{"options with keying material", true, []byte{0, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			origin := &datagram{
+				Flag:    composeFlag(payloadSessionRequest, test.rekey, true),
+				Time:    uint32(time.Now().Unix()),
+				Options: test.opts,
+				Payload: []byte("this is the payload"),
+			}
+			if test.rekey {
+				origin.KeyingMaterial = material
+			}
+			b, err := origin.MarshalBinary(key, key)
+			if err != nil {
+				t.Fatalf("error in MarshalBinary: %v", err)
+			}
+
+			destination := new(datagram)
+			if err := destination.unmarshal(b, key, key); err != nil {
+				t.Fatalf("error in unmarshal: %v", err)
+			}
+			if !bytes.Equal(destination.Options, test.opts) {
+				t.Errorf("options %v, expected %v", destination.Options, test.opts)
+			}
+			if !bytes.HasPrefix(destination.Payload, origin.Payload) {
+				t.Errorf("payload %q, expected %q", destination.Payload, origin.Payload)
+			}
+		})
+	}
+
+	// Options are limited by their size byte
+	d := &datagram{Flag: composeFlag(payloadSessionRequest, false, true), Options: make([]byte, maxOptionsSize+1)}
+	if _, err := d.MarshalBinary(key, key); err == nil {
+		t.Error("no error when marshalling oversized options")
+	}
+}
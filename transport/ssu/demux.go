@@ -0,0 +1,112 @@
+package ssu
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// demuxInboxSize is the number of datagrams a demuxConn buffers before dropping them
+const demuxInboxSize = 64
+
+// demuxConn is a net.Conn to a single remote peer over a shared net.PacketConn
+// The datagrams coming from the remote peer are fed to it by whoever reads the shared connection
+type demuxConn struct {
+	pc    net.PacketConn
+	raddr net.Addr
+
+	// Datagrams received from the remote peer
+	inbox chan []byte
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	// Called once when the connection is closed
+	onClose func()
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newDemuxConn(pc net.PacketConn, raddr net.Addr, onClose func()) *demuxConn {
+	return &demuxConn{
+		pc:            pc,
+		raddr:         raddr,
+		inbox:         make(chan []byte, demuxInboxSize),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		onClose:       onClose,
+		closed:        make(chan struct{}),
+	}
+}
+
+// deliver queues a datagram received from the remote peer
+// As with UDP, if the queue is full the datagram is dropped
+func (dc *demuxConn) deliver(b []byte) {
+	select {
+	case dc.inbox <- b:
+	default:
+	}
+}
+
+// Read reads a single datagram from the remote peer
+func (dc *demuxConn) Read(b []byte) (int, error) {
+	select {
+	case d := <-dc.inbox:
+		return copy(b, d), nil
+	case <-dc.closed:
+		return 0, io.EOF
+	case <-dc.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write writes a single datagram to the remote peer
+func (dc *demuxConn) Write(b []byte) (int, error) {
+	select {
+	case <-dc.closed:
+		return 0, net.ErrClosed
+	case <-dc.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	return dc.pc.WriteTo(b, dc.raddr)
+}
+
+// Close closes the connection, but not the shared net.PacketConn
+func (dc *demuxConn) Close() error {
+	dc.closeOnce.Do(func() {
+		close(dc.closed)
+		if dc.onClose != nil {
+			dc.onClose()
+		}
+	})
+	return nil
+}
+
+// LocalAddr returns the local address of the shared net.PacketConn
+func (dc *demuxConn) LocalAddr() net.Addr { return dc.pc.LocalAddr() }
+
+// RemoteAddr returns the remote peer's address
+func (dc *demuxConn) RemoteAddr() net.Addr { return dc.raddr }
+
+// SetDeadline sets both the read and write deadlines
+func (dc *demuxConn) SetDeadline(t time.Time) error {
+	dc.readDeadline.set(t)
+	dc.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline
+func (dc *demuxConn) SetReadDeadline(t time.Time) error {
+	dc.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline
+func (dc *demuxConn) SetWriteDeadline(t time.Time) error {
+	dc.writeDeadline.set(t)
+	return nil
+}
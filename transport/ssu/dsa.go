@@ -0,0 +1,122 @@
+package ssu
+
+import (
+	"crypto/dsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"math/big"
+)
+
+const (
+	// dsaSignatureSize is the size of a DSA-SHA1 signature: 20 bytes of R followed by 20 bytes of S
+	dsaSignatureSize = 40
+
+	// dsaPrivateKeySize is the size of a DSA-SHA1 signing private key
+	dsaPrivateKeySize = 20
+
+	// dsaPublicKeySize is the size of a DSA-SHA1 signing public key
+	dsaPublicKeySize = 128
+)
+
+// dsaParameters are the DSA parameters used throughout I2P
+var dsaParameters = dsa.Parameters{
+	P: bigFromHex("9C05B2AA960D9B97B8931963C9CC9E8C3026E9B8ED92FAD0A69CC886D5BF8015FCADAE31A0AD18FAB3F01B00A358DE237655C4964AFAA2B337E96AD316B9FB1CC564B5AEC5B69A9FF6C3E4548707FEF8503D91DD8602E867E6D35D2235C1869CE2479C3B9D5401DE04E0727FB33D6511285D4CF29538D9E3B6051F5B22CC1C93"),
+	Q: bigFromHex("A5DFC28FEF4CA1E286744CD8EED9D29D684046B7"),
+	G: bigFromHex("0C1F4D27D40093B429E962D7223824E0BBC47E7C832A39236FC683AF84889581075FF9082ED32353D4374D7301CDA1D23C431F4698599DDA02451824FF369752593647CC3DDC197DE985E43D136CDCFC6BD5409CD2F450821142A5E6F8EB1C3AB5D0484B8129FCF17BCE4F7F33321C3CB3DBB14A905E7B2B3E93BE4708CBCC82"),
+}
+
+// bigFromHex parses an hexadecimal constant, panicking if it is invalid
+func bigFromHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hexadecimal constant: " + s)
+	}
+	return n
+}
+
+// dsaSigner is a DSA-SHA1 Signer
+type dsaSigner struct {
+	priv *dsa.PrivateKey
+}
+
+// NewDSASigner returns a DSA-SHA1 Signer, given the 20 bytes signing private key
+func NewDSASigner(privKey []byte) (Signer, error) {
+	if len(privKey) != dsaPrivateKeySize {
+		return nil, errors.New("invalid DSA private key size")
+	}
+
+	// Build the private key
+	x := new(big.Int).SetBytes(privKey)
+	priv := &dsa.PrivateKey{
+		PublicKey: dsa.PublicKey{
+			Parameters: dsaParameters,
+			Y:          new(big.Int).Exp(dsaParameters.G, x, dsaParameters.P),
+		},
+		X: x,
+	}
+
+	return &dsaSigner{priv: priv}, nil
+}
+
+// Sign hashes the data with SHA1 and signs it
+func (s *dsaSigner) Sign(data []byte) ([]byte, error) {
+	// Hash
+	hashed := sha1.Sum(data)
+
+	// Sign
+	r, ss, err := dsa.Sign(rand.Reader, s.priv, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// R and S are both written as 20 bytes big-endian integers
+	sig := make([]byte, dsaSignatureSize)
+	r.FillBytes(sig[:dsaSignatureSize/2])
+	ss.FillBytes(sig[dsaSignatureSize/2:])
+
+	return sig, nil
+}
+
+// SignatureSize returns the length of DSA-SHA1 signatures
+func (s *dsaSigner) SignatureSize() int { return dsaSignatureSize }
+
+// dsaVerifier is a DSA-SHA1 Verifier
+type dsaVerifier struct {
+	pub *dsa.PublicKey
+}
+
+// newDSAVerifier returns a DSA-SHA1 Verifier, given the 128 bytes signing public key
+func newDSAVerifier(pubKey []byte) (*dsaVerifier, error) {
+	if len(pubKey) != dsaPublicKeySize {
+		return nil, errors.New("invalid DSA public key size")
+	}
+
+	// Build the public key
+	pub := &dsa.PublicKey{
+		Parameters: dsaParameters,
+		Y:          new(big.Int).SetBytes(pubKey),
+	}
+
+	return &dsaVerifier{pub: pub}, nil
+}
+
+// Verify hashes the data with SHA1 and checks its signature
+func (v *dsaVerifier) Verify(data []byte, sig []byte) bool {
+	if len(sig) != dsaSignatureSize {
+		return false
+	}
+
+	// Hash
+	hashed := sha1.Sum(data)
+
+	// Extract R and S
+	r := new(big.Int).SetBytes(sig[:dsaSignatureSize/2])
+	s := new(big.Int).SetBytes(sig[dsaSignatureSize/2:])
+
+	// Verify
+	return dsa.Verify(v.pub, hashed[:], r, s)
+}
+
+// SignatureSize returns the length of DSA-SHA1 signatures
+func (v *dsaVerifier) SignatureSize() int { return dsaSignatureSize }
@@ -0,0 +1,124 @@
+package ssu
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Parameters of the duplicate filter a Transport uses if none is given
+	defaultDuplicateFalsePositiveRate = 0.0001
+	defaultDuplicateFilterSize        = 256 * 1024
+	defaultDuplicateDecay             = 10 * time.Minute
+)
+
+// A DuplicateFilter remembers the IDs of the messages received recently, so that a message received twice, as
+// retransmitted over a session or sent over several, is only delivered once. It may be shared by several sessions,
+// as the spec recommends that a router uses a single one across all its peers.
+/*
+It is a decaying Bloom filter made of two generations: IDs are added to the current one, and looked up in both. The
+current generation becomes the previous one, and the previous one is cleared, once the decay period elapses or once the
+current one holds as many IDs as it can with the chosen false positive rate. An ID is thus remembered for at least the
+decay period, unless the filter is saturated, and for at most twice that period.
+*/
+type DuplicateFilter struct {
+	mu sync.Mutex
+
+	// Bits of the current and previous generations
+	current, previous []uint64
+
+	// Number of hashes of each ID, and number of IDs a generation may hold
+	hashes   int
+	capacity int
+
+	// Number of IDs in the current generation, and when it started
+	added   int
+	started time.Time
+
+	decay time.Duration
+	seed  maphash.Seed
+}
+
+// NewDuplicateFilter creates a DuplicateFilter using at most maxBytes of memory, remembering IDs for at least the decay
+// period with the given false positive rate, that is the probability of dropping a message which isn't a duplicate.
+// The number of IDs it can remember for the whole period is bounded by the memory.
+func NewDuplicateFilter(falsePositiveRate float64, maxBytes int, decay time.Duration) *DuplicateFilter {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = defaultDuplicateFalsePositiveRate
+	}
+	if maxBytes < 2*8 {
+		maxBytes = 2 * 8
+	}
+
+	// Each generation takes half of the memory
+	words := maxBytes / 2 / 8
+	bits := float64(words * 64)
+
+	// The optimal number of hashes for that rate, and the number of IDs such a filter holds while keeping to it
+	hashes := int(math.Ceil(-math.Log2(falsePositiveRate)))
+	capacity := int(bits * math.Ln2 * math.Ln2 / -math.Log(falsePositiveRate))
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &DuplicateFilter{
+		current:  make([]uint64, words),
+		previous: make([]uint64, words),
+		hashes:   hashes,
+		capacity: capacity,
+		decay:    decay,
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+// newDefaultDuplicateFilter creates a DuplicateFilter with the default parameters
+func newDefaultDuplicateFilter() *DuplicateFilter {
+	return NewDuplicateFilter(defaultDuplicateFalsePositiveRate, defaultDuplicateFilterSize, defaultDuplicateDecay)
+}
+
+// positions returns the two hashes of an ID from which the positions of its bits are derived, as in double hashing
+// The hash is seeded at random, so that the positions can't be predicted by the peers.
+func (f *DuplicateFilter) positions(id uint32) (h1 uint64, h2 uint64) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], id)
+	h := maphash.Bytes(f.seed, b[:])
+	return h & 0xffffffff, h>>32 | 1
+}
+
+// check records an ID, returning whether it was already present
+func (f *DuplicateFilter) check(id uint32, now time.Time) (duplicate bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Decay
+	if f.started.IsZero() {
+		f.started = now
+	}
+	if now.Sub(f.started) >= f.decay || f.added >= f.capacity {
+		f.current, f.previous = f.previous, f.current
+		for i := range f.current {
+			f.current[i] = 0
+		}
+		f.added = 0
+		f.started = now
+	}
+
+	// Look the ID up in both generations, adding it to the current one
+	bits := uint64(len(f.current) * 64)
+	h1, h2 := f.positions(id)
+	inCurrent, inPrevious := true, true
+	for i := 0; i < f.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % bits
+		word, mask := pos/64, uint64(1)<<(pos%64)
+		inCurrent = inCurrent && f.current[word]&mask != 0
+		inPrevious = inPrevious && f.previous[word]&mask != 0
+		f.current[word] |= mask
+	}
+	if !inCurrent {
+		f.added++
+	}
+	return inCurrent || inPrevious
+}
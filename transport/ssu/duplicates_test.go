@@ -0,0 +1,94 @@
+package ssu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	const decay = time.Minute
+	f := NewDuplicateFilter(0.001, 64*1024, decay)
+	now := time.Now()
+
+	// Memory is bounded
+	if size := 8 * (len(f.current) + len(f.previous)); size > 64*1024 {
+		t.Errorf("filter of %d bytes, beyond the bound", size)
+	}
+
+	// Duplicates are detected
+	if f.check(42, now) {
+		t.Error("new ID reported as a duplicate")
+	}
+	if !f.check(42, now.Add(time.Second)) {
+		t.Error("duplicate not detected")
+	}
+
+	// For at least the decay period
+	f.check(43, now)
+	if !f.check(43, now.Add(decay+time.Second)) {
+		t.Error("duplicate not detected after a single decay")
+	}
+
+	// But no more than twice that
+	f.check(44, now.Add(2*decay))
+	f.check(45, now.Add(3*decay+time.Second))
+	if f.check(44, now.Add(4*decay+2*time.Second)) {
+		t.Error("ID remembered beyond twice the decay period")
+	}
+}
+
+func TestDuplicateFilter_FalsePositives(t *testing.T) {
+	const rate = 0.01
+	f := NewDuplicateFilter(rate, 16*1024, time.Hour)
+	now := time.Now()
+
+	// Fill the filter to its capacity
+	for id := uint32(0); id < uint32(f.capacity-1); id++ {
+		f.check(id, now)
+	}
+
+	// New IDs are rarely taken for duplicates
+	const tries = 10000
+	positives := 0
+	for id := uint32(1 << 30); id < 1<<30+tries; id++ {
+		if f.check(id, now) {
+			positives++
+		}
+	}
+	if float64(positives)/tries > 2*rate {
+		t.Errorf("false positive rate of %v, expected about %v", float64(positives)/tries, rate)
+	}
+}
+
+func TestTransport_Duplicates(t *testing.T) {
+	bob := newTestTransport(t, newTestDialer(t))
+	ab, ba := dialTransport(t, newTestTransport(t, newTestDialer(t)), bob)
+	cb, bc := dialTransport(t, newTestTransport(t, newTestDialer(t)), bob)
+
+	// A message is sent twice over a session, and delivered once
+	fragments, err := fragmentMessage(42, []byte("duplicate"), ab.maxFragmentSize())
+	if err != nil {
+		t.Fatalf("error in fragmentMessage: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ab.writeFragment(fragments[0]); err != nil {
+			t.Fatalf("error in writeFragment: %v", err)
+		}
+	}
+	if got := readMessage(t, ba); string(got) != "duplicate" {
+		t.Errorf("got %q", got)
+	}
+
+	// Then once again over another session, where it isn't delivered
+	if err := cb.writeFragment(fragments[0]); err != nil {
+		t.Fatalf("error in writeFragment: %v", err)
+	}
+	for _, pair := range []struct{ from, to *Conn }{{ab, ba}, {cb, bc}} {
+		if _, err := pair.from.Write([]byte("next")); err != nil {
+			t.Fatalf("error in Write: %v", err)
+		}
+		if got := readMessage(t, pair.to); string(got) != "next" {
+			t.Errorf("got %q instead of the next message", got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package ssu
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	_ "crypto/sha256" // Registers SHA256 for crypto.Hash
+	_ "crypto/sha512" // Registers SHA384 & SHA512 for crypto.Hash
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ecdsaParameters are the curve and hash of each ECDSA signature type
+// Both public keys (X then Y) and signatures (R then S) are made of two big-endian integers of fieldSize bytes
+var ecdsaParameters = map[sigType]struct {
+	curve     elliptic.Curve
+	hash      crypto.Hash
+	fieldSize int
+}{
+	sigTypeECDSASHA256P256: {elliptic.P256(), crypto.SHA256, 32},
+	sigTypeECDSASHA384P384: {elliptic.P384(), crypto.SHA384, 48},
+	sigTypeECDSASHA512P521: {elliptic.P521(), crypto.SHA512, 66},
+}
+
+// ecdsaSigner is an ECDSA Signer
+type ecdsaSigner struct {
+	priv *ecdsa.PrivateKey
+	st   sigType
+}
+
+// NewECDSASigner returns an ECDSA Signer, given a signing private key on the P-256, P-384 or P-521 curve
+// The hash used is respectively SHA256, SHA384 or SHA512
+func NewECDSASigner(priv *ecdsa.PrivateKey) (Signer, error) {
+	for st, params := range ecdsaParameters {
+		if priv.Curve == params.curve {
+			return &ecdsaSigner{priv: priv, st: st}, nil
+		}
+	}
+	return nil, errors.New("unsupported ECDSA curve")
+}
+
+// Sign hashes the data and signs it
+func (s *ecdsaSigner) Sign(data []byte) ([]byte, error) {
+	params := ecdsaParameters[s.st]
+
+	// Hash
+	hasher := params.hash.New()
+	hasher.Write(data)
+	hashed := hasher.Sum(nil)
+
+	// Sign
+	r, ss, err := ecdsa.Sign(rand.Reader, s.priv, hashed)
+	if err != nil {
+		return nil, err
+	}
+
+	// R and S are both written as big-endian integers of the size of the field
+	sig := make([]byte, 2*params.fieldSize)
+	r.FillBytes(sig[:params.fieldSize])
+	ss.FillBytes(sig[params.fieldSize:])
+
+	return sig, nil
+}
+
+// SignatureSize returns the length of the signatures
+func (s *ecdsaSigner) SignatureSize() int { return 2 * ecdsaParameters[s.st].fieldSize }
+
+// ecdsaVerifier is an ECDSA Verifier
+type ecdsaVerifier struct {
+	pub *ecdsa.PublicKey
+	st  sigType
+}
+
+// newECDSAVerifier returns an ECDSA Verifier for the given signature type, given the signing public key
+func newECDSAVerifier(st sigType, pubKey []byte) (*ecdsaVerifier, error) {
+	params, ok := ecdsaParameters[st]
+	if !ok {
+		return nil, fmt.Errorf("signature type %d is not ECDSA", st)
+	} else if len(pubKey) != 2*params.fieldSize {
+		return nil, errors.New("invalid ECDSA public key size")
+	}
+
+	// Build the public key
+	pub := &ecdsa.PublicKey{
+		Curve: params.curve,
+		X:     new(big.Int).SetBytes(pubKey[:params.fieldSize]),
+		Y:     new(big.Int).SetBytes(pubKey[params.fieldSize:]),
+	}
+
+	return &ecdsaVerifier{pub: pub, st: st}, nil
+}
+
+// Verify hashes the data and checks its signature
+func (v *ecdsaVerifier) Verify(data []byte, sig []byte) bool {
+	params := ecdsaParameters[v.st]
+	if len(sig) != 2*params.fieldSize {
+		return false
+	}
+
+	// Hash
+	hasher := params.hash.New()
+	hasher.Write(data)
+	hashed := hasher.Sum(nil)
+
+	// Extract R and S
+	r := new(big.Int).SetBytes(sig[:params.fieldSize])
+	s := new(big.Int).SetBytes(sig[params.fieldSize:])
+
+	// Verify
+	return ecdsa.Verify(v.pub, hashed, r, s)
+}
+
+// SignatureSize returns the length of the signatures
+func (v *ecdsaVerifier) SignatureSize() int { return 2 * ecdsaParameters[v.st].fieldSize }
@@ -0,0 +1,64 @@
+package ssu
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+const (
+	// ed25519PublicKeySize is the size of an EdDSA-SHA512-Ed25519 signing public key
+	ed25519PublicKeySize = ed25519.PublicKeySize
+
+	// ed25519SignatureSize is the size of an EdDSA-SHA512-Ed25519 signature
+	ed25519SignatureSize = ed25519.SignatureSize
+)
+
+// ed25519Signer is an EdDSA-SHA512-Ed25519 Signer
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns an EdDSA-SHA512-Ed25519 Signer, given the signing private key
+func NewEd25519Signer(priv ed25519.PrivateKey) (Signer, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid Ed25519 private key size")
+	}
+	return &ed25519Signer{priv: priv}, nil
+}
+
+// Sign signs the data, which is hashed by the algorithm itself
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.priv, data), nil
+}
+
+// SignatureSize returns the length of EdDSA-SHA512-Ed25519 signatures
+func (s *ed25519Signer) SignatureSize() int { return ed25519SignatureSize }
+
+// ed25519Verifier is an EdDSA-SHA512-Ed25519 Verifier
+type ed25519Verifier struct {
+	pub ed25519.PublicKey
+}
+
+// newEd25519Verifier returns an EdDSA-SHA512-Ed25519 Verifier, given the signing public key
+func newEd25519Verifier(pubKey []byte) (*ed25519Verifier, error) {
+	if len(pubKey) != ed25519PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key size")
+	}
+
+	// Copy the key, as we don't retain pubKey
+	pub := make(ed25519.PublicKey, ed25519PublicKeySize)
+	copy(pub, pubKey)
+
+	return &ed25519Verifier{pub: pub}, nil
+}
+
+// Verify checks the signature of the data
+func (v *ed25519Verifier) Verify(data []byte, sig []byte) bool {
+	if len(sig) != ed25519SignatureSize {
+		return false
+	}
+	return ed25519.Verify(v.pub, data, sig)
+}
+
+// SignatureSize returns the length of EdDSA-SHA512-Ed25519 signatures
+func (v *ed25519Verifier) SignatureSize() int { return ed25519SignatureSize }
@@ -4,7 +4,7 @@ package ssu
    Bit order: 76543210 (bit 7 is MSB)
 
    bits 7-4: payload type, as a 4-bit integer
-      bit 3: If 1, rekey data is included. Always 0, unimplemented
+      bit 3: If 1, rekey data is included
       bit 2: If 1, extended options are included. Always 0 before release
              0.9.24.
    bits 1-0: reserved, set to 0 for compatibility with future uses
@@ -0,0 +1,187 @@
+package ssu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFragments is the maximum number of fragments of a message, as in the current Java implementation
+	maxFragments = 64
+
+	// reassemblyTimeout is the time after which an incomplete inbound message is dropped
+	reassemblyTimeout = 10 * time.Second
+
+	// maxPendingMessages is the maximum number of incomplete inbound messages of a session
+	maxPendingMessages = 256
+)
+
+// errMessageTooLarge is returned when a message doesn't fit in maxFragments fragments
+var errMessageTooLarge = errors.New("message too large to be fragmented")
+
+// fragmentMessage splits a message in fragments of at most fragmentSize bytes
+// The fragments retain msg
+func fragmentMessage(id uint32, msg []byte, fragmentSize int) ([]dataFragment, error) {
+	if fragmentSize <= 0 || fragmentSize > maxFragmentSize {
+		return nil, fmt.Errorf("invalid fragment size %d", fragmentSize)
+	}
+
+	// Count the fragments, an empty message still needing one
+	total := (len(msg) + fragmentSize - 1) / fragmentSize
+	if total == 0 {
+		total = 1
+	} else if total > maxFragments {
+		return nil, errMessageTooLarge
+	}
+
+	// Split it
+	fragments := make([]dataFragment, total)
+	for i := range fragments {
+		start := i * fragmentSize
+		end := start + fragmentSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+		fragments[i] = dataFragment{
+			MessageID: id,
+			Num:       byte(i),
+			IsLast:    i == total-1,
+			Data:      msg[start:end],
+		}
+	}
+
+	return fragments, nil
+}
+
+// inboundMessage is a message being reassembled
+type inboundMessage struct {
+	// Fragments received so far
+	fragments [maxFragmentNum + 1][]byte
+	received  fragmentSet
+
+	// Number of the last fragment, or -1 if it hasn't been received yet
+	last int
+
+	// Time after which the message is dropped if still incomplete
+	expires time.Time
+}
+
+// reassembler reassembles inbound messages from their fragments, by message ID
+type reassembler struct {
+	mu       sync.Mutex
+	messages map[uint32]*inboundMessage
+
+	// Recently completed messages, whose retransmitted fragments are ignored, with their expiration time
+	completed map[uint32]time.Time
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		messages:  make(map[uint32]*inboundMessage),
+		completed: make(map[uint32]time.Time),
+	}
+}
+
+// ackState returns the fragments received of a message, and whether it was completed
+func (r *reassembler) ackState(id uint32) (received fragmentSet, complete bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.completed[id]; ok {
+		return fragmentSet{}, true
+	}
+	if m, ok := r.messages[id]; ok {
+		return m.received, false
+	}
+	return fragmentSet{}, false
+}
+
+// add adds a fragment, returning the reassembled message once all of its fragments have been received
+// Duplicate fragments are ignored, and fragments inconsistent with the ones already received drop the message
+func (r *reassembler) add(f dataFragment, now time.Time) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Ignore the fragments of completed messages, retransmitted as our ACK was lost
+	if _, ok := r.completed[f.MessageID]; ok {
+		return nil, nil
+	}
+
+	// Find the message, or start a new one
+	m, ok := r.messages[f.MessageID]
+	if !ok {
+		if len(r.messages) >= maxPendingMessages {
+			return nil, errors.New("too many incomplete messages")
+		}
+		m = &inboundMessage{
+			last:    -1,
+			expires: now.Add(reassemblyTimeout),
+		}
+		r.messages[f.MessageID] = m
+	}
+
+	// Check its consistency
+	switch {
+	case f.IsLast && m.last != -1 && int(f.Num) != m.last:
+		delete(r.messages, f.MessageID)
+		return nil, fmt.Errorf("message %d has two last fragments: %d and %d", f.MessageID, m.last, f.Num)
+	case f.IsLast && m.received.highest() > int(f.Num):
+		delete(r.messages, f.MessageID)
+		return nil, fmt.Errorf("message %d has fragment %d after its last fragment %d", f.MessageID, m.received.highest(), f.Num)
+	case !f.IsLast && m.last != -1 && int(f.Num) > m.last:
+		delete(r.messages, f.MessageID)
+		return nil, fmt.Errorf("message %d has fragment %d after its last fragment %d", f.MessageID, f.Num, m.last)
+	}
+
+	// Ignore duplicates
+	if m.received.has(f.Num) {
+		return nil, nil
+	}
+
+	// Store it
+	data := make([]byte, len(f.Data))
+	copy(data, f.Data)
+	m.fragments[f.Num] = data
+	m.received.add(f.Num)
+	if f.IsLast {
+		m.last = int(f.Num)
+	}
+
+	// Check for completion
+	if m.last == -1 || m.received.len() != m.last+1 {
+		return nil, nil
+	}
+	delete(r.messages, f.MessageID)
+	r.completed[f.MessageID] = now.Add(reassemblyTimeout)
+
+	// Reassemble it
+	size := 0
+	for _, fragment := range m.fragments[:m.last+1] {
+		size += len(fragment)
+	}
+	msg := make([]byte, 0, size)
+	for _, fragment := range m.fragments[:m.last+1] {
+		msg = append(msg, fragment...)
+	}
+
+	return msg, nil
+}
+
+// expire drops the incomplete messages which expired, and forgets the completed ones which did
+func (r *reassembler) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, m := range r.messages {
+		if now.After(m.expires) {
+			delete(r.messages, id)
+		}
+	}
+	for id, expires := range r.completed {
+		if now.After(expires) {
+			delete(r.completed, id)
+		}
+	}
+}
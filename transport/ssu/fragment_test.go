@@ -0,0 +1,146 @@
+package ssu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestFragmentMessage(t *testing.T) {
+	var tests = []struct {
+		desc         string
+		size         int
+		fragmentSize int
+		expected     int
+	}{
+		{"empty", 0, 100, 1},
+		{"smaller than a fragment", 50, 100, 1},
+		{"exactly one fragment", 100, 100, 1},
+		{"partial last fragment", 250, 100, 3},
+		{"maximum fragments", maxFragments * 100, 100, maxFragments},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			msg := make([]byte, test.size)
+			rand.Read(msg)
+
+			fragments, err := fragmentMessage(42, msg, test.fragmentSize)
+			if err != nil {
+				t.Fatalf("error in fragmentMessage: %v", err)
+			}
+			if len(fragments) != test.expected {
+				t.Fatalf("expected %d fragments, got %d", test.expected, len(fragments))
+			}
+
+			// Check them
+			var reassembled []byte
+			for i, f := range fragments {
+				if f.MessageID != 42 || int(f.Num) != i || f.IsLast != (i == len(fragments)-1) {
+					t.Errorf("fragment %d: unexpected header %d/%d/%t", i, f.MessageID, f.Num, f.IsLast)
+				}
+				if len(f.Data) > test.fragmentSize {
+					t.Errorf("fragment %d: %d bytes exceeds the fragment size", i, len(f.Data))
+				}
+				reassembled = append(reassembled, f.Data...)
+			}
+			if !bytes.Equal(reassembled, msg) {
+				t.Error("fragments don't add up to the message")
+			}
+		})
+	}
+}
+
+func TestFragmentMessage_Invalid(t *testing.T) {
+	if _, err := fragmentMessage(0, make([]byte, maxFragments*100+1), 100); err != errMessageTooLarge {
+		t.Errorf("expected errMessageTooLarge, got %v", err)
+	}
+	if _, err := fragmentMessage(0, nil, 0); err == nil {
+		t.Error("expected an error with a null fragment size")
+	}
+	if _, err := fragmentMessage(0, nil, maxFragmentSize+1); err == nil {
+		t.Error("expected an error with a fragment size too large")
+	}
+}
+
+func TestReassembler(t *testing.T) {
+	msg := make([]byte, 1000)
+	rand.Read(msg)
+	fragments, err := fragmentMessage(7, msg, 100)
+	if err != nil {
+		t.Fatalf("error in fragmentMessage: %v", err)
+	}
+
+	// Add them in reverse order, with duplicates
+	r := newReassembler()
+	now := time.Now()
+	for i := len(fragments) - 1; i >= 0; i-- {
+		for j := 0; j < 2; j++ {
+			got, err := r.add(fragments[i], now)
+			if err != nil {
+				t.Fatalf("error in add: %v", err)
+			}
+			switch {
+			case i == 0 && j == 0:
+				if !bytes.Equal(got, msg) {
+					t.Error("reassembled message differs")
+				}
+			case got != nil:
+				t.Errorf("unexpected message after fragment %d", i)
+			}
+		}
+	}
+
+	// Retransmitted fragments of the completed message are ignored
+	if got, err := r.add(fragments[0], now); got != nil || err != nil {
+		t.Errorf("retransmitted fragment wasn't ignored: %v, %v", got, err)
+	}
+	if _, complete := r.ackState(7); !complete {
+		t.Error("message isn't reported as complete")
+	}
+}
+
+func TestReassembler_Inconsistent(t *testing.T) {
+	var tests = []struct {
+		desc      string
+		fragments []dataFragment
+	}{
+		{"two last fragments", []dataFragment{{Num: 1, IsLast: true}, {Num: 2, IsLast: true}}},
+		{"fragment after the last", []dataFragment{{Num: 1, IsLast: true}, {Num: 2}}},
+		{"last before a received fragment", []dataFragment{{Num: 2}, {Num: 1, IsLast: true}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r := newReassembler()
+			now := time.Now()
+			if _, err := r.add(test.fragments[0], now); err != nil {
+				t.Fatalf("error in add: %v", err)
+			}
+			if _, err := r.add(test.fragments[1], now); err == nil {
+				t.Error("expected an error")
+			}
+
+			// The message must have been dropped
+			if len(r.messages) != 0 {
+				t.Error("inconsistent message wasn't dropped")
+			}
+		})
+	}
+}
+
+func TestReassembler_Expire(t *testing.T) {
+	r := newReassembler()
+	now := time.Now()
+	r.add(dataFragment{MessageID: 1, Num: 0}, now)
+	r.add(dataFragment{MessageID: 2, Num: 0}, now.Add(reassemblyTimeout/2))
+
+	r.expire(now.Add(reassemblyTimeout + time.Millisecond))
+	if _, ok := r.messages[1]; ok {
+		t.Error("expired message wasn't dropped")
+	}
+	if _, ok := r.messages[2]; !ok {
+		t.Error("pending message was dropped")
+	}
+}
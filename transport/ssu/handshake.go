@@ -0,0 +1,146 @@
+package ssu
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// handshakeTimeout is the time after which a session establishment is abandoned, if the context doesn't specify a deadline
+	handshakeTimeout = 10 * time.Second
+
+	// handshakeResendInterval is the interval at which a handshake message is resent until it is answered
+	handshakeResendInterval = time.Second
+)
+
+// watchContext applies the context's deadline to the given connection, and unblocks it if the context is cancelled
+// The returned function must be called once the handshake is done, it resets the deadline and may be called several times
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	// Set the deadline
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	// Watch for a cancellation
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+			conn.SetDeadline(time.Time{})
+		})
+	}
+}
+
+// shortIP returns the 4 bytes form of an IPv4 address, as IP addresses are sent in their shortest form
+func shortIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// writeDatagram embeds the payload in a datagram of the given type, marshals it and sends it over the connection
+func writeDatagram(conn net.Conn, payloadType byte, payload []byte, macKey []byte, cryptoKey []byte) error {
+	// Marshal the datagram
+	b, err := marshalDatagram(payloadType, payload, macKey, cryptoKey)
+	if err != nil {
+		return err
+	}
+
+	// Send it
+	_, err = conn.Write(b)
+	return err
+}
+
+// marshalDatagram embeds the payload in a datagram of the given type and marshals it
+func marshalDatagram(payloadType byte, payload []byte, macKey []byte, cryptoKey []byte) ([]byte, error) {
+	// Embed it into a datagram
+	d := &datagram{
+		Flag:    composeFlag(payloadType, false, false),
+		Time:    uint32(time.Now().Unix()),
+		Payload: payload,
+	}
+
+	// Marshal it
+	return d.MarshalBinary(macKey, cryptoKey)
+}
+
+// resend writes datagrams to the connection every handshakeResendInterval, as they or their answer may have been
+// lost, until the returned function is called
+func resend(conn net.Conn, datagrams ...[]byte) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(handshakeResendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, b := range datagrams {
+					conn.Write(b)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// readDatagram reads datagrams from the connection until one of the given type is successfully authenticated
+// and decrypted with the given keys. Datagrams that cannot be authenticated, or of another type, are discarded.
+func readDatagram(ctx context.Context, conn net.Conn, payloadType byte, macKey []byte, cryptoKey []byte) (*datagram, error) {
+	return readDatagramOr(ctx, conn, payloadType, macKey, cryptoKey, nil)
+}
+
+// readDatagramOr reads datagrams as readDatagram does, handing the ones it discards to the given function if any
+func readDatagramOr(ctx context.Context, conn net.Conn, payloadType byte, macKey []byte, cryptoKey []byte, discarded func(b []byte)) (*datagram, error) {
+	buf := make([]byte, maximumDatagramSize)
+	for {
+		// Read a datagram
+		n, err := conn.Read(buf)
+		if err != nil {
+			// If the context is done, that's the reason why
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		// Unmarshal it and check its type, discarding it if it isn't for us
+		d := new(datagram)
+		err = d.unmarshal(buf[:n], macKey, cryptoKey)
+		if p, _, _ := decomposeFlag(d.Flag); err != nil || p != payloadType {
+			if discarded != nil {
+				discarded(buf[:n])
+			}
+			continue
+		}
+
+		return d, nil
+	}
+}
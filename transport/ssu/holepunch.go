@@ -0,0 +1,27 @@
+package ssu
+
+import (
+	"net"
+)
+
+// handleRelayIntro answers an introduction from one of our introducers, acting as Charlie
+// A hole punch, that is an empty UDP packet, is sent to Alice so that our NAT lets the session request through.
+// Introductions are only accepted over an established session with the introducer.
+func (t *Transport) handleRelayIntro(conn *Conn, payload []byte) {
+	if conn == nil {
+		return
+	}
+
+	intro := new(relayIntro)
+	if err := intro.UnmarshalBinary(payload); err != nil {
+		return
+	}
+
+	// Alice must be reachable over IPv4
+	if intro.AliceAddr.IP.To4() == nil || intro.AliceAddr.Port == 0 {
+		return
+	}
+
+	// Punch the hole
+	t.pc.WriteTo(nil, &net.UDPAddr{IP: intro.AliceAddr.IP, Port: intro.AliceAddr.Port})
+}
@@ -0,0 +1,105 @@
+package ssu
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHolePunch(t *testing.T) {
+	bobOpts := newTestDialer(t)
+	bobOpts.MaxIntroducedPeers = 1
+	bob := newTestTransport(t, bobOpts)
+	charlie := newTestTransport(t, newTestDialer(t))
+	cb, _ := dialTransport(t, charlie, bob)
+
+	// Alice asks Bob for an introduction to Charlie
+	alice, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error in ListenUDP: %v", err)
+	}
+	defer alice.Close()
+	aliceIntroKey := make([]byte, introKeySize)
+	rand.Read(aliceIntroKey)
+	req := &relayRequest{
+		RelayTag: [4]byte{byte(cb.RelayTag() >> 24), byte(cb.RelayTag() >> 16), byte(cb.RelayTag() >> 8), byte(cb.RelayTag())},
+		Nonce:    42,
+	}
+	copy(req.IntroKey[:], aliceIntroKey)
+	b, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	d := &datagram{Flag: composeFlag(payloadRelayRequest, false, false), Time: uint32(time.Now().Unix()), Payload: b}
+	db, err := d.MarshalBinary(bobOpts.Introkey, bobOpts.Introkey)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	if _, err := alice.WriteTo(db, bob.Addr()); err != nil {
+		t.Fatalf("error in WriteTo: %v", err)
+	}
+
+	// Alice gets both Bob's response and Charlie's hole punch
+	var gotResponse, gotHolePunch bool
+	buf := make([]byte, maximumDatagramSize)
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !gotResponse || !gotHolePunch {
+		n, addr, err := alice.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("error in ReadFrom (response: %t, hole punch: %t): %v", gotResponse, gotHolePunch, err)
+		}
+
+		switch addr.String() {
+		case charlie.Addr().String():
+			if n != 0 {
+				t.Errorf("hole punch isn't empty but %d bytes", n)
+			}
+			gotHolePunch = true
+		case bob.Addr().String():
+			rd := new(datagram)
+			if err := rd.unmarshal(buf[:n], aliceIntroKey, aliceIntroKey); err != nil {
+				t.Fatalf("error unmarshalling the response: %v", err)
+			}
+			resp := new(relayResponse)
+			if err := resp.UnmarshalBinary(rd.Payload); err != nil {
+				t.Fatalf("error unmarshalling the response: %v", err)
+			}
+			if resp.Nonce != 42 || resp.CharlieAddr.String() != charlie.Addr().String() {
+				t.Errorf("unexpected response %+v", resp)
+			}
+			gotResponse = true
+		}
+	}
+}
+
+func TestHolePunch_OutOfSession(t *testing.T) {
+	charlieOpts := newTestDialer(t)
+	charlie := newTestTransport(t, charlieOpts)
+
+	alice, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error in ListenUDP: %v", err)
+	}
+	defer alice.Close()
+
+	// A relay intro using Charlie's intro key is ignored, as anyone could send it
+	intro := &relayIntro{AliceAddr: *alice.LocalAddr().(*net.UDPAddr)}
+	b, err := intro.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	d := &datagram{Flag: composeFlag(payloadRelayIntro, false, false), Time: uint32(time.Now().Unix()), Payload: b}
+	db, err := d.MarshalBinary(charlieOpts.Introkey, charlieOpts.Introkey)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	if _, err := alice.WriteTo(db, charlie.Addr()); err != nil {
+		t.Fatalf("error in WriteTo: %v", err)
+	}
+
+	alice.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := alice.ReadFrom(make([]byte, 1)); err == nil {
+		t.Errorf("unexpected packet of %d bytes", n)
+	}
+}
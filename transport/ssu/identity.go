@@ -0,0 +1,156 @@
+package ssu
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// Sizes of the fixed-length parts of a RouterIdentity
+	identityPublicKeySize  = 256
+	identitySigningKeySize = 128
+	identityCertHeaderSize = 3
+
+	// identityMinSize is the size of a RouterIdentity with a null certificate
+	identityMinSize = identityPublicKeySize + identitySigningKeySize + identityCertHeaderSize // 387B
+)
+
+/*
+A RouterIdentity is laid out as follows:
+
++----+----+----+----+----+----+----+----+
+| public_key                            |
++                                       +
+|                                       |
+~                                       ~
+~                                       ~
+|                                       |
++----+----+----+----+----+----+----+----+
+| padding (optional)                    |
+~                                       ~
+~                                       ~
+|                                       |
++----+----+----+----+----+----+----+----+
+| signing_key                           |
++                                       +
+|                                       |
+~                                       ~
+~                                       ~
+|                                       |
++----+----+----+----+----+----+----+----+
+| certificate                           |
++----+----+----+-//
+
+Which, with the DSA-SHA1 signing key and a null certificate, makes a 387 bytes
+long identity. Other signing keys require a key certificate.
+*/
+
+// Certificate types
+const (
+	certTypeNull = 0
+	certTypeKey  = 5
+)
+
+// identityCertificate extracts the type and payload of the certificate of a binary RouterIdentity
+// It retains ri
+func identityCertificate(ri []byte) (byte, []byte, error) {
+	if len(ri) < identityMinSize {
+		return 0, nil, errors.New("router identity is invalid: too small")
+	}
+
+	// The certificate follows the keys
+	certPos := identityPublicKeySize + identitySigningKeySize
+	certLen := int(binary.BigEndian.Uint16(ri[certPos+1 : certPos+3]))
+	if len(ri) < identityMinSize+certLen {
+		return 0, nil, errors.New("router identity is invalid: certificate truncated")
+	}
+
+	return ri[certPos], ri[identityMinSize : identityMinSize+certLen], nil
+}
+
+/*
+identitySigType returns the signature type of a binary RouterIdentity
+Since release 0.9.16 it is specified by a key certificate, whose payload is:
+
++----+----+----+----+----+-//
+|type| type| excess key data
++----+----+----+----+----+-//
+
+With the signing key type first, then the crypto key type. Without a key certificate, it is DSA-SHA1.
+*/
+func identitySigType(ri []byte) (sigType, error) {
+	certType, payload, err := identityCertificate(ri)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a key certificate, it's DSA-SHA1
+	if certType != certTypeKey {
+		return sigTypeDSASHA1, nil
+	}
+
+	// Extract the signing key type
+	if len(payload) < 4 {
+		return 0, errors.New("key certificate is invalid: too small")
+	}
+	return sigType(binary.BigEndian.Uint16(payload[:2])), nil
+}
+
+// identitySigningPublicKey extracts the signing public key from a binary RouterIdentity
+// A key shorter than 128 bytes is at the end of the signing key field, a longer one continues in the key certificate
+// It does not retain ri
+func identitySigningPublicKey(ri []byte) ([]byte, error) {
+	// Get the signature type, and with it the key size
+	st, err := identitySigType(ri)
+	if err != nil {
+		return nil, err
+	}
+	size, err := st.publicKeySize()
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the signing key
+	key := make([]byte, size)
+	field := ri[identityPublicKeySize : identityPublicKeySize+identitySigningKeySize]
+	if size <= identitySigningKeySize {
+		copy(key, field[identitySigningKeySize-size:])
+		return key, nil
+	}
+
+	// The excess is at the beginning of the excess key data of the key certificate
+	_, payload, err := identityCertificate(ri)
+	if err != nil {
+		return nil, err
+	}
+	excess := size - identitySigningKeySize
+	if len(payload) < 4+excess {
+		return nil, errors.New("key certificate is invalid: too small for excess signing key data")
+	}
+	copy(key, field)
+	copy(key[identitySigningKeySize:], payload[4:4+excess])
+
+	return key, nil
+}
+
+// identityVerifier returns a Verifier for the signatures made with the signing key of a binary RouterIdentity
+func identityVerifier(ri []byte) (Verifier, error) {
+	st, err := identitySigType(ri)
+	if err != nil {
+		return nil, err
+	}
+	key, err := identitySigningPublicKey(ri)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifier(st, key)
+}
+
+// identitySignatureSize returns the length of the signatures made with the signing key of a binary RouterIdentity
+func identitySignatureSize(ri []byte) (int, error) {
+	v, err := identityVerifier(ri)
+	if err != nil {
+		return 0, err
+	}
+	return v.SignatureSize(), nil
+}
@@ -0,0 +1,248 @@
+package ssu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// relayRequestInterval is the interval at which relay requests are resent until one of the introducers responds
+	relayRequestInterval = time.Second
+
+	// holePunchTimeout is the time we wait for the peer's hole punch once an introducer responded, after which we dial
+	// it anyway as the hole punch may have been lost, the session request being resent until answered
+	holePunchTimeout = time.Second
+)
+
+// An Introducer relays session requests to a firewalled peer, as published in the peer's RouterAddress
+type Introducer struct {
+	// Address and intro key of the introducer itself
+	Addr     *net.UDPAddr
+	IntroKey []byte
+
+	// RelayTag identifies the firewalled peer to the introducer
+	RelayTag uint32
+}
+
+// pendingRelay is a relay request waiting for a response, and for the hole punch of the peer
+type pendingRelay struct {
+	introducers []Introducer
+	responses   chan *relayResponse
+
+	// Address of the peer given by the first response, nil until then, and closed once the peer punched a hole
+	mu      sync.Mutex
+	charlie *net.UDPAddr
+	punched chan struct{}
+}
+
+// responded records the address of the peer given by a response, returning false if one was already given
+func (pr *pendingRelay) responded(charlie *net.UDPAddr) bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.charlie != nil {
+		return false
+	}
+	pr.charlie = charlie
+	return true
+}
+
+// holePunched records a hole punch, ignoring it unless it comes from the peer we were given
+func (pr *pendingRelay) holePunched(addr net.Addr) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.charlie == nil || addr.String() != pr.charlie.String() || isClosedChan(pr.punched) {
+		return
+	}
+	close(pr.punched)
+}
+
+// waitHolePunch waits for the hole punch of the peer, returning false if none arrives before the timeout or ctx is done
+func (pr *pendingRelay) waitHolePunch(ctx context.Context, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-pr.punched:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// from checks whether an address is the one of an introducer the request was sent to
+func (pr *pendingRelay) from(addr net.Addr) bool {
+	for _, intro := range pr.introducers {
+		if intro.Addr.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// DialIndirect dials a firewalled peer through a new UDP socket, given its introducers, intro key and binary RouterIdentity
+// The socket is closed along with the returned Conn
+func (d *Dialer) DialIndirect(ctx context.Context, introducers []Introducer, introKey []byte, identity []byte) (*Conn, error) {
+	// Open the socket
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTransport(pc, d)
+
+	// Dial
+	conn, err := t.DialIndirect(ctx, introducers, introKey, identity)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	// As we created the transport, we are the ones who must close it
+	go func() {
+		<-conn.closed
+		t.Close()
+	}()
+
+	return conn, nil
+}
+
+// DialIndirect dials a firewalled peer over the shared socket, given its introducers, intro key and binary RouterIdentity
+/*
+A relay request is sent to each introducer, and the first to respond gives us the peer's address.
+Meanwhile, the peer is asked by the introducer to send us a hole punch, so that our session request goes through its NAT.
+
+       Alice                         Bob                  Charlie
+   RelayRequest ---------------------->
+        <-------------- RelayResponse    RelayIntro ----------->
+        <-------------------------------------------- HolePunch
+   SessionRequest -------------------------------------------->
+        <-------------------------------------------- SessionCreated
+   SessionConfirmed ------------------------------------------>
+*/
+func (t *Transport) DialIndirect(ctx context.Context, introducers []Introducer, introKey []byte, identity []byte) (*Conn, error) {
+	if len(introducers) == 0 {
+		return nil, errors.New("no introducer given")
+	} else if len(t.opts.Introkey) != introKeySize {
+		return nil, errors.New("invalid intro key size")
+	}
+	for _, intro := range introducers {
+		if intro.Addr == nil {
+			return nil, errors.New("introducer without address")
+		}
+	}
+
+	// Register the request
+	nonce, pr, err := t.registerRelay(introducers)
+	if err != nil {
+		return nil, err
+	}
+	defer t.unregisterRelay(nonce)
+
+	// Prepare it
+	rr := &relayRequest{
+		Nonce: nonce,
+	}
+	copy(rr.IntroKey[:], t.opts.Introkey)
+
+	// Send it to each introducer until one responds
+	ticker := time.NewTicker(relayRequestInterval)
+	defer ticker.Stop()
+	for {
+		for _, intro := range introducers {
+			binary.BigEndian.PutUint32(rr.RelayTag[:], intro.RelayTag)
+			b, err := rr.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			t.writeControl(intro.Addr, intro.IntroKey, payloadRelayRequest, b)
+		}
+
+		select {
+		case resp := <-pr.responses:
+			// Dial the peer at the address we were given, once its hole punch tells us its NAT lets us through
+			charlie := &net.UDPAddr{IP: resp.CharlieAddr.IP, Port: resp.CharlieAddr.Port}
+			pr.waitHolePunch(ctx, holePunchTimeout)
+			return t.Dial(ctx, charlie, introKey, identity)
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.closed:
+			return nil, t.err
+		}
+	}
+}
+
+// registerRelay registers a relay request to the given introducers, returning its nonce
+func (t *Transport) registerRelay(introducers []Introducer) (uint32, *pendingRelay, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Pick an unused nonce
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, nil, err
+		}
+		nonce := binary.BigEndian.Uint32(b[:])
+		if _, ok := t.relays[nonce]; ok {
+			continue
+		}
+
+		pr := &pendingRelay{
+			introducers: introducers,
+			responses:   make(chan *relayResponse, 1),
+			punched:     make(chan struct{}),
+		}
+		t.relays[nonce] = pr
+		return nonce, pr, nil
+	}
+}
+
+// unregisterRelay forgets a relay request
+func (t *Transport) unregisterRelay(nonce uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.relays, nonce)
+}
+
+// handleRelayResponse hands a relay response over to the request it answers
+// Responses from peers the request wasn't sent to are ignored
+func (t *Transport) handleRelayResponse(addr net.Addr, payload []byte) {
+	resp := new(relayResponse)
+	if err := resp.UnmarshalBinary(payload); err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	pr, ok := t.relays[resp.Nonce]
+	t.mu.Unlock()
+	if !ok || !pr.from(addr) {
+		return
+	}
+
+	// Only the first response matters
+	if !pr.responded(&net.UDPAddr{IP: resp.CharlieAddr.IP, Port: resp.CharlieAddr.Port}) {
+		return
+	}
+	pr.responses <- resp
+}
+
+// handleHolePunch hands a hole punch, that is an empty datagram, over to the pending relay requests
+// Only the ones whose response gave the address it comes from take it into account.
+func (t *Transport) handleHolePunch(addr net.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, pr := range t.relays {
+		pr.holePunched(addr)
+	}
+}
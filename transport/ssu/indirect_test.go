@@ -0,0 +1,181 @@
+package ssu
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeIntroducer answers the relay requests for a given tag with the address of a peer, standing in for Bob
+// It returns the introducer to give to DialIndirect
+func fakeIntroducer(t *testing.T, tag uint32, charlie *net.UDPAddr) Introducer {
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error in ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	introKey := make([]byte, introKeySize)
+	rand.Read(introKey)
+
+	go func() {
+		buf := make([]byte, maximumDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			// Read the request
+			d := new(datagram)
+			if err := d.unmarshal(buf[:n], introKey, introKey); err != nil {
+				continue
+			}
+			if p, _, _ := decomposeFlag(d.Flag); p != payloadRelayRequest {
+				continue
+			}
+			req := new(relayRequest)
+			if err := req.UnmarshalBinary(d.Payload); err != nil {
+				t.Errorf("error unmarshalling the relay request: %v", err)
+				continue
+			}
+			if req.RelayTag != [4]byte{byte(tag >> 24), byte(tag >> 16), byte(tag >> 8), byte(tag)} {
+				continue
+			}
+
+			// Respond with Alice's intro key
+			alice := addr.(*net.UDPAddr)
+			resp := &relayResponse{
+				CharlieAddr: net.UDPAddr{IP: shortIP(charlie.IP), Port: charlie.Port},
+				AliceAddr:   net.UDPAddr{IP: shortIP(alice.IP), Port: alice.Port},
+				Nonce:       req.Nonce,
+			}
+			b, err := resp.MarshalBinary()
+			if err != nil {
+				t.Errorf("error marshalling the relay response: %v", err)
+				continue
+			}
+			rd := &datagram{Flag: composeFlag(payloadRelayResponse, false, false), Time: uint32(time.Now().Unix()), Payload: b}
+			rb, err := rd.MarshalBinary(req.IntroKey[:], req.IntroKey[:])
+			if err != nil {
+				t.Errorf("error marshalling the datagram: %v", err)
+				continue
+			}
+			pc.WriteTo(rb, addr)
+		}
+	}()
+
+	return Introducer{
+		Addr:     pc.LocalAddr().(*net.UDPAddr),
+		IntroKey: introKey,
+		RelayTag: tag,
+	}
+}
+
+func TestDialIndirect(t *testing.T) {
+	charlie := newTestDialer(t)
+	charlieTransport := newTestTransport(t, charlie)
+	charlieAddr := charlieTransport.Addr().(*net.UDPAddr)
+
+	// One introducer doesn't know Charlie, the other does
+	introducers := []Introducer{
+		fakeIntroducer(t, 1, charlieAddr),
+		fakeIntroducer(t, 2, charlieAddr),
+	}
+	introducers[0].RelayTag = 3
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := charlieTransport.AcceptSSU()
+		if err != nil {
+			t.Errorf("error in AcceptSSU: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	// Dial Charlie through them
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	aliceConn, err := newTestDialer(t).DialIndirect(ctx, introducers, charlie.Introkey, charlie.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in DialIndirect: %v", err)
+	}
+	defer aliceConn.Close()
+	charlieConn := <-accepted
+	if charlieConn == nil {
+		t.FailNow()
+	}
+
+	// The session works
+	if _, err := aliceConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	if got := readMessage(t, charlieConn); string(got) != "hello" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestDialIndirect_NoResponse(t *testing.T) {
+	charlie := newTestDialer(t)
+	charlieAddr := newTestTransport(t, charlie).Addr().(*net.UDPAddr)
+	intro := fakeIntroducer(t, 1, charlieAddr)
+	intro.RelayTag = 2
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := newTestDialer(t).DialIndirect(ctx, []Introducer{intro}, charlie.Introkey, charlie.RouterIdentity); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestDialIndirect_NoIntroducer(t *testing.T) {
+	charlie := newTestDialer(t)
+	if _, err := newTestDialer(t).DialIndirect(context.Background(), nil, charlie.Introkey, charlie.RouterIdentity); err == nil {
+		t.Error("expected an error without introducers")
+	}
+}
+
+func TestDialIndirect_HolePunch(t *testing.T) {
+	alice := newTestTransport(t, newTestDialer(t))
+	_, pr, err := alice.registerRelay([]Introducer{{Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}})
+	if err != nil {
+		t.Fatalf("error in registerRelay: %v", err)
+	}
+	punch := func(from *net.UDPConn) {
+		if _, err := from.WriteTo(nil, alice.Addr()); err != nil {
+			t.Fatalf("error in WriteTo: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	// Charlie and another peer
+	charlie, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error in ListenUDP: %v", err)
+	}
+	defer charlie.Close()
+	other, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("error in ListenUDP: %v", err)
+	}
+	defer other.Close()
+
+	// Hole punches are ignored until a response tells us where Charlie is
+	punch(charlie)
+	if pr.waitHolePunch(ctx, 50*time.Millisecond) {
+		t.Error("hole punch taken into account before the response")
+	}
+	pr.responded(charlie.LocalAddr().(*net.UDPAddr))
+
+	// Then only Charlie's is
+	punch(other)
+	if pr.waitHolePunch(ctx, 50*time.Millisecond) {
+		t.Error("hole punch taken into account from another peer than Charlie")
+	}
+	punch(charlie)
+	if !pr.waitHolePunch(ctx, 5*time.Second) {
+		t.Error("hole punch not received")
+	}
+}
@@ -0,0 +1,131 @@
+package ssu
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// relayTags maps the relay tags we issued to the sessions of the peers we introduce, acting as Bob
+type relayTags struct {
+	mu sync.Mutex
+
+	// Maximum number of tags issued at once, zero disabling introductions
+	limit int
+
+	// Sessions by tag, nil while the session is being established
+	sessions map[uint32]*Conn
+}
+
+func newRelayTags(limit int) *relayTags {
+	return &relayTags{
+		limit:    limit,
+		sessions: make(map[uint32]*Conn),
+	}
+}
+
+// issue picks a new relay tag, returning zero if we can't introduce another peer
+func (rt *relayTags) issue() uint32 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if len(rt.sessions) >= rt.limit {
+		return 0
+	}
+
+	// Pick an unused non-zero tag
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0
+		}
+		tag := binary.BigEndian.Uint32(b[:])
+		if _, ok := rt.sessions[tag]; tag == 0 || ok {
+			continue
+		}
+		rt.sessions[tag] = nil
+		return tag
+	}
+}
+
+// bind associates an issued tag with the session it was issued in, until the session ends
+func (rt *relayTags) bind(tag uint32, conn *Conn) {
+	rt.mu.Lock()
+	rt.sessions[tag] = conn
+	rt.mu.Unlock()
+
+	go func() {
+		<-conn.closed
+		rt.release(tag)
+	}()
+}
+
+// release forgets a tag
+func (rt *relayTags) release(tag uint32) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	delete(rt.sessions, tag)
+}
+
+// lookup returns the session of the peer a tag was issued to, or nil if there is none
+func (rt *relayTags) lookup(tag uint32) *Conn {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.sessions[tag]
+}
+
+// handleRelayRequest introduces Alice to Charlie, the peer identified by the relay tag of the request
+// We send a relay intro to Charlie so that a hole is punched in Charlie's NAT, and a relay response to Alice with
+// Charlie's address. As relaying isn't done over IPv6, requests from IPv6 addresses are ignored, as are the ones
+// with an unknown tag.
+func (t *Transport) handleRelayRequest(addr net.Addr, payload []byte) {
+	req := new(relayRequest)
+	if err := req.UnmarshalBinary(payload); err != nil {
+		return
+	}
+
+	// Find Charlie
+	charlie := t.relayTags.lookup(binary.BigEndian.Uint32(req.RelayTag[:]))
+	if charlie == nil {
+		return
+	}
+	charlieAddr, ok := charlie.RemoteAddr().(*net.UDPAddr)
+	if !ok || charlieAddr.IP.To4() == nil {
+		return
+	}
+
+	// Alice's address, as we see it
+	aliceAddr, ok := addr.(*net.UDPAddr)
+	if !ok || aliceAddr.IP.To4() == nil {
+		return
+	}
+	alice := net.UDPAddr{IP: aliceAddr.IP.To4(), Port: aliceAddr.Port}
+
+	// Introduce Alice to Charlie
+	intro := &relayIntro{
+		AliceAddr: alice,
+		Challenge: req.Challenge,
+	}
+	b, err := intro.MarshalBinary()
+	if err != nil {
+		return
+	}
+	if err := charlie.writeControl(payloadRelayIntro, b); err != nil {
+		return
+	}
+
+	// Give Charlie's address to Alice
+	resp := &relayResponse{
+		CharlieAddr: net.UDPAddr{IP: charlieAddr.IP.To4(), Port: charlieAddr.Port},
+		AliceAddr:   alice,
+		Nonce:       req.Nonce,
+	}
+	b, err = resp.MarshalBinary()
+	if err != nil {
+		return
+	}
+	t.writeControl(aliceAddr, req.IntroKey[:], payloadRelayResponse, b)
+}
@@ -0,0 +1,123 @@
+package ssu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayTags(t *testing.T) {
+	rt := newRelayTags(2)
+
+	// Tags are unique and non-zero, up to the limit
+	a, b := rt.issue(), rt.issue()
+	if a == 0 || b == 0 || a == b {
+		t.Fatalf("invalid tags issued: %d, %d", a, b)
+	}
+	if c := rt.issue(); c != 0 {
+		t.Errorf("tag %d issued beyond the limit", c)
+	}
+
+	// A bound tag is released once its session ends
+	conn := &Conn{closed: make(chan struct{})}
+	rt.bind(a, conn)
+	if rt.lookup(a) != conn {
+		t.Error("bound tag doesn't lead to its session")
+	}
+	close(conn.closed)
+	for deadline := time.Now().Add(5 * time.Second); rt.lookup(a) != nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("tag not released after its session ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Which makes room for another one
+	if c := rt.issue(); c == 0 {
+		t.Error("no tag issued after one was released")
+	}
+
+	// Introductions may be disabled
+	if tag := newRelayTags(0).issue(); tag != 0 {
+		t.Errorf("tag %d issued while introductions are disabled", tag)
+	}
+}
+
+func TestIntroducer(t *testing.T) {
+	bobOpts := newTestDialer(t)
+	bobOpts.MaxIntroducedPeers = 1
+	bob := newTestTransport(t, bobOpts)
+	charlieOpts := newTestDialer(t)
+	charlie := newTestTransport(t, charlieOpts)
+
+	// Charlie gets a relay tag from Bob
+	cb, bc := dialTransport(t, charlie, bob)
+	tag := cb.RelayTag()
+	if tag == 0 {
+		t.Fatal("no relay tag offered")
+	}
+	if bc.RelayTag() != tag {
+		t.Errorf("Bob issued tag %d, Charlie got %d", bc.RelayTag(), tag)
+	}
+
+	// No one else does, as Bob introduces a single peer
+	db, _ := dialTransport(t, newTestTransport(t, newTestDialer(t)), bob)
+	if db.RelayTag() != 0 {
+		t.Errorf("relay tag %d offered beyond the limit", db.RelayTag())
+	}
+
+	// Alice is introduced to Charlie by Bob
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := charlie.AcceptSSU()
+		if err != nil {
+			t.Errorf("error in AcceptSSU: %v", err)
+		}
+		accepted <- conn
+	}()
+	introducer := Introducer{
+		Addr:     bob.Addr().(*net.UDPAddr),
+		IntroKey: bobOpts.Introkey,
+		RelayTag: tag,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ac, err := newTestDialer(t).DialIndirect(ctx, []Introducer{introducer}, charlieOpts.Introkey, charlieOpts.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in DialIndirect: %v", err)
+	}
+	defer ac.Close()
+	if ca := <-accepted; ca != nil {
+		ca.Close()
+	}
+
+	// Once Charlie's session with Bob ends, the tag expires
+	cb.Close()
+	for deadline := time.Now().Add(5 * time.Second); bob.relayTags.lookup(tag) != nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("tag not released after the session ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIntroducer_NoRelayTag(t *testing.T) {
+	bobOpts := newTestDialer(t)
+	bobOpts.MaxIntroducedPeers = 1
+	bob := newTestTransport(t, bobOpts)
+
+	// Alice tells Bob not to issue a tag
+	aliceOpts := newTestDialer(t)
+	aliceOpts.NoRelayTag = true
+	ab, ba := dialTransport(t, newTestTransport(t, aliceOpts), bob)
+	if ab.RelayTag() != 0 || ba.RelayTag() != 0 {
+		t.Errorf("relay tag %d issued although not requested", ba.RelayTag())
+	}
+
+	// Which leaves it for Charlie
+	cb, _ := dialTransport(t, newTestTransport(t, newTestDialer(t)), bob)
+	if cb.RelayTag() == 0 {
+		t.Error("no relay tag offered")
+	}
+}
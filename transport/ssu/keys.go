@@ -3,13 +3,29 @@ package ssu
 import (
 	"crypto/sha256"
 	"errors"
+	"math/big"
 )
 
 const (
 	macKeySize     = 32
 	sessionKeySize = 32
+	introKeySize   = 32
 )
 
+// javaBytes represents an exchanged DH key as Java's BigInteger.toByteArray() does, as the key derivations require
+// That is a positive minimal-length byte array (two's complement big-endian): the leading zero bytes, such as the ones
+// of dhkx's fixed-length representation, are dropped, and a 0x00 byte is prepended if the most significant bit is 1.
+func javaBytes(dhKey []byte) ([]byte, error) {
+	b := new(big.Int).SetBytes(dhKey).Bytes()
+	if len(b) == 0 {
+		return nil, errors.New("dhKey is zero: cannot proceed")
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0x00}, b...)
+	}
+	return b, nil
+}
+
 /*
 The 32-byte session key is created as follows:
 
@@ -26,27 +42,16 @@ The 32-byte session key is created as follows:
    bytes. *Very unlikely - See note below.*
 */
 func sessionKeyFromDHKey(dhKey []byte) ([]byte, error) {
-	if len(dhKey) == 0 {
-		return nil, errors.New("dhKey length is 0: cannot proceed")
-	}
-
-	// If the most significant bit is 1, we prepend a 0x00 byte
-	// Note: the append here is acceptable as the main slice is created on the spot ([]byte{0x00})
-	// and the assignement doesn't change the underlying array
-	if dhKey[0]&0x80 != 0 {
-		dhKey = append([]byte{0x00}, dhKey...)
-	}
-
-	// If the byte array length is more than or equal to 32 bytes, we slice it to 32 bytes
-	if len(dhKey) >= 32 {
-		sessionKey := make([]byte, sessionKeySize)
-		copy(sessionKey, dhKey[33:64])
-		return sessionKey, nil
+	// Represent it as a minimal-length BigInteger, prepending a 0x00 byte if the most significant bit is 1
+	b, err := javaBytes(dhKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// If it isn't, we append 0x00 bytes to extend it to 32 bytes in length
-	prepend := make([]byte, 32-len(dhKey))
-	return append(prepend, dhKey...), nil
+	// Use the first 32 bytes, appending 0x00 bytes if there are less
+	sessionKey := make([]byte, sessionKeySize)
+	copy(sessionKey, b)
+	return sessionKey, nil
 }
 
 /*
@@ -62,25 +67,32 @@ The 32-byte MAC key is created as follows:
    that byte array. *As of release 0.9.8. See note below.*
 */
 func macKeyFromDHKey(dhKey []byte) ([]byte, error) {
-	if len(dhKey) == 0 {
-		return nil, errors.New("dhKey length is 0: cannot proceed")
-	}
-
-	// If the most significant bit is 1, we prepend a 0x00 byte
-	// Note: the append here is acceptable as the main slice is created on the spot ([]byte{0x00})
-	// and the assignement doesn't change the underlying array
-	if dhKey[0]&0x80 != 0 {
-		dhKey = append([]byte{0x00}, dhKey...)
+	// Represent it as a minimal-length BigInteger, prepending a 0x00 byte if the most significant bit is 1
+	b, err := javaBytes(dhKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// If that byte array is greater than or equal to 64 bytes, the MAC key is bytes 33-64 from that byte array.
-	if len(dhKey) >= 64 {
+	// If that byte array is greater than or equal to 64 bytes, the MAC key is bytes 33-64 (counting from one)
+	if len(b) >= 64 {
 		macKey := make([]byte, macKeySize)
-		copy(macKey, dhKey[33:64])
+		copy(macKey, b[32:64])
 		return macKey, nil
 	}
 
-	// Else, we take the SHA-256 Hash of the dhKey
-	sha := sha256.Sum256(dhKey)
+	// Else, we take the SHA-256 Hash of it
+	sha := sha256.Sum256(b)
 	return sha[:], nil
 }
+
+// keysFromKeyingMaterial derives the new MAC and session keys from the 64 bytes of keying material of a rekeying
+// The first 32 bytes are fed into SHA-256 to produce the MAC key, and the next 32 bytes the session key
+func keysFromKeyingMaterial(material []byte) (macKey []byte, sessionKey []byte, err error) {
+	if len(material) != keyingMaterialSize {
+		return nil, nil, errors.New("invalid keying material length")
+	}
+
+	mac := sha256.Sum256(material[:32])
+	session := sha256.Sum256(material[32:])
+	return mac[:], session[:], nil
+}
@@ -1,6 +1,11 @@
 package ssu
 
-import "testing"
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
 
 func TestMacKey(t *testing.T) {
 	mac, err := macKeyFromDHKey([]byte("test dh key"))
@@ -21,3 +26,144 @@ func TestSessionKey(t *testing.T) {
 		t.Errorf("session key len (%d) is not expected key len (%d)", len(session), sessionKeySize)
 	}
 }
+
+// dhSharedKey returns the key Alice and Bob exchange with the given private exponents, as dhkx represents it:
+// left-padded to the size of the group's prime
+func dhSharedKey(a uint64, b uint64) []byte {
+	x := new(big.Int).Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+	shared := new(big.Int).Exp(big.NewInt(2), x, dhGroup.P())
+	return shared.FillBytes(make([]byte, 256))
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// TestJavaBytes checks the representation of DH keys against the outputs of Java's BigInteger.toByteArray(), as
+// documented by the JDK, which the Java router derives the keys from
+func TestJavaBytes(t *testing.T) {
+	var tests = []struct {
+		dhKey    string
+		expected string
+	}{
+		{"7f", "7f"},
+		{"80", "0080"},
+		{"ff", "00ff"},
+		{"0100", "0100"},
+		{"8000", "008000"},
+		{"0000007f", "7f"},
+		{"00000080", "0080"},
+		{"00ff00", "00ff00"},
+	}
+
+	for _, test := range tests {
+		got, err := javaBytes(mustDecodeHex(test.dhKey))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.dhKey, err)
+		} else if expected := mustDecodeHex(test.expected); !bytes.Equal(got, expected) {
+			t.Errorf("%s: got %x, expected %x", test.dhKey, got, expected)
+		}
+	}
+}
+
+// The expected keys were derived by following the spec's steps on Java's BigInteger.toByteArray() representation, so
+// they only guard against regressions: compatibility with the Java router is tested by TestKeysFromDHKey_JavaRouter.
+func TestKeysFromDHKey(t *testing.T) {
+	var tests = []struct {
+		desc       string
+		dhKey      []byte
+		sessionKey string
+		macKey     string
+	}{
+		{
+			desc:       "most significant bit clear",
+			dhKey:      dhSharedKey(0x0123456789abcdef, 1),
+			sessionKey: "396beaa14a3ea5a31d40a99230fb3fb556eceabfd18f94af7ca61c292161c353",
+			macKey:     "90e15ee2dd70366c82cc4c67c94ca9fe3b4f3bdef62606136607993dcedd07bb",
+		},
+		{
+			desc:       "most significant bit set",
+			dhKey:      dhSharedKey(0x0123456789abcdef, 2),
+			sessionKey: "0097a491eaee4abaea01a0442e6392a48084e691765a3f9b92f95bbd2e223623",
+			macKey:     "772d743ffca786ba463d49d7185c23010ff8258660b266417b02dff76c6de730",
+		},
+		{
+			desc:       "leading zero byte",
+			dhKey:      dhSharedKey(0x0123456789abcdef, 0x3dd),
+			sessionKey: "672fc3849e93a5f4c64a374ef1e6ad4efe537021c66bba990d09e0fdcaf4866b",
+			macKey:     "3870f57d9583d945bf446d243dd940c1724288209a207e5b4852bae1f119e991",
+		},
+		{
+			desc:       "shorter than 32 bytes",
+			dhKey:      mustDecodeHex("0102030405060708090a0b0c0d0e0f1011121314"),
+			sessionKey: "0102030405060708090a0b0c0d0e0f1011121314000000000000000000000000",
+			macKey:     "e12f08743344c0eab7afaa22bb71e2725f7e13dece6ac2d23711054ee787b6c2",
+		},
+		{
+			desc:       "between 32 and 64 bytes",
+			dhKey:      mustDecodeHex("800102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021222324252627"),
+			sessionKey: "00800102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e",
+			macKey:     "859a09fffee2a30d46981876739ac09cc58a263d3391857f3dc6b5549642ace0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			sessionKey, err := sessionKeyFromDHKey(test.dhKey)
+			if err != nil {
+				t.Fatalf("error in sessionKeyFromDHKey: %v", err)
+			}
+			if expected := mustDecodeHex(test.sessionKey); !bytes.Equal(sessionKey, expected) {
+				t.Errorf("session key is %x, expected %x", sessionKey, expected)
+			}
+
+			macKey, err := macKeyFromDHKey(test.dhKey)
+			if err != nil {
+				t.Fatalf("error in macKeyFromDHKey: %v", err)
+			}
+			if expected := mustDecodeHex(test.macKey); !bytes.Equal(macKey, expected) {
+				t.Errorf("MAC key is %x, expected %x", macKey, expected)
+			}
+		})
+	}
+
+	// A zero key can't be represented
+	if _, err := sessionKeyFromDHKey(make([]byte, 256)); err == nil {
+		t.Error("expected an error for a zero key")
+	}
+}
+
+// javaRouterKeys are keys derived by the Java router's DHSessionKeyBuilder from the shared secret of a session, as
+// captured from it
+// None has been captured yet.
+var javaRouterKeys = []struct {
+	sharedSecret string
+	sessionKey   string
+	macKey       string
+}{}
+
+func TestKeysFromDHKey_JavaRouter(t *testing.T) {
+	if len(javaRouterKeys) == 0 {
+		t.Skip("no keys captured from the Java router")
+	}
+
+	for _, test := range javaRouterKeys {
+		sessionKey, err := sessionKeyFromDHKey(mustDecodeHex(test.sharedSecret))
+		if err != nil {
+			t.Errorf("%s: error in sessionKeyFromDHKey: %v", test.sharedSecret, err)
+		} else if expected := mustDecodeHex(test.sessionKey); !bytes.Equal(sessionKey, expected) {
+			t.Errorf("%s: session key is %x, expected %x", test.sharedSecret, sessionKey, expected)
+		}
+
+		macKey, err := macKeyFromDHKey(mustDecodeHex(test.sharedSecret))
+		if err != nil {
+			t.Errorf("%s: error in macKeyFromDHKey: %v", test.sharedSecret, err)
+		} else if expected := mustDecodeHex(test.macKey); !bytes.Equal(macKey, expected) {
+			t.Errorf("%s: MAC key is %x, expected %x", test.sharedSecret, macKey, expected)
+		}
+	}
+}
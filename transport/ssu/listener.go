@@ -0,0 +1,253 @@
+package ssu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/monnand/dhkx"
+)
+
+// Listen announces on the local network address, returning a Transport over a new UDP socket
+// The network must be "udp", "udp4" or "udp6"
+func Listen(network, laddr string, opts *Dialer) (*Transport, error) {
+	// Resolve the address
+	addr, err := net.ResolveUDPAddr(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Listen
+	pc, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTransport(pc, opts), nil
+}
+
+// A Transport is a net.Listener of the sessions established by remote peers
+var _ net.Listener = (*Transport)(nil)
+
+// Accept waits for and returns the next session established by a remote peer, as a *Conn
+// It implements net.Listener; AcceptSSU returns the *Conn directly.
+func (t *Transport) Accept() (net.Conn, error) {
+	conn, err := t.AcceptSSU()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// AcceptSSU waits for and returns the next session established by a remote peer
+func (t *Transport) AcceptSSU() (*Conn, error) {
+	select {
+	case conn := <-t.accepted:
+		return conn, nil
+	case <-t.closed:
+		return nil, t.err
+	}
+}
+
+// handshake establishes the session, and queues it to be accepted
+func (t *Transport) handshake(dc *demuxConn) {
+	ctx, cancel := context.WithTimeout(t.ctx, handshakeTimeout)
+	defer cancel()
+
+	// Establish the session
+	conn, err := t.establish(ctx, dc)
+	if err != nil {
+		dc.Close()
+		return
+	}
+	t.established(dc, conn)
+	if conn.relayTag != 0 {
+		t.relayTags.bind(conn.relayTag, conn)
+	}
+
+	// Queue it
+	select {
+	case t.accepted <- conn:
+	case <-t.closed:
+		conn.Close()
+	}
+}
+
+// establish does the Bob side of the handshake over a net.Conn to a single peer
+/*
+       Alice                         Bob
+   SessionRequest --------------------->
+         <--------------------- SessionCreated
+   SessionConfirmed ------------------->
+*/
+func (t *Transport) establish(ctx context.Context, dc *demuxConn) (conn *Conn, err error) {
+	// Alice's address, as we see it
+	raddr, ok := dc.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("remote address is not an UDP address")
+	}
+	aliceIP := shortIP(raddr.IP)
+
+	// Our port
+	laddr, ok := dc.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("local address is not an UDP address")
+	}
+
+	// Apply the context to the connection for the duration of the handshake
+	stop := watchContext(ctx, dc)
+	defer stop()
+
+	// STEP 1: Receive a Session Request
+
+	// It is encrypted with our intro key
+	srd, err := readDatagram(ctx, dc, payloadSessionRequest, t.opts.Introkey, t.opts.Introkey)
+	if err != nil {
+		return nil, err
+	}
+	// Unmarshal it
+	sr := new(sessionRequest)
+	err = sr.UnmarshalBinary(srd.Payload)
+	if err != nil {
+		return nil, err
+	}
+	// Alice requests a relay tag unless its extended options say otherwise
+	relayTagRequested := true
+	if _, _, extended := decomposeFlag(srd.Flag); extended {
+		opts := new(sessionRequestOptions)
+		err = opts.UnmarshalBinary(srd.Options)
+		if err != nil {
+			return nil, err
+		}
+		relayTagRequested = opts.RelayTagRequested
+	}
+
+	// STEP 2: Session Created
+
+	// Generate private key
+	priv, err := dhGroup.GeneratePrivateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	// Compute the shared secret from X
+	shared, err := dhGroup.ComputeKey(dhkx.NewPublicKey(sr.X[:]), priv)
+	if err != nil {
+		return nil, err
+	}
+	// Derive the session and mac keys
+	sessionKey, err := sessionKeyFromDHKey(shared.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := macKeyFromDHKey(shared.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	// Generate the IV of the datagram now, as it is reused to encrypt the signature
+	iv := make([]byte, flagPos-ivPos)
+	_, err = rand.Read(iv)
+	if err != nil {
+		return nil, err
+	}
+	// Offer to introduce Alice if requested and we can, that is over IPv4
+	var relayTag uint32
+	if relayTagRequested && raddr.IP.To4() != nil {
+		relayTag = t.relayTags.issue()
+	}
+	if relayTag != 0 {
+		defer func() {
+			if err != nil {
+				t.relayTags.release(relayTag)
+			}
+		}()
+	}
+	// Prepare the message
+	sc := &sessionCreated{
+		Addr:       net.UDPAddr{IP: aliceIP, Port: raddr.Port},
+		SignedOn:   uint32(time.Now().Unix()),
+		X:          sr.X,
+		MyAddr:     net.UDPAddr{IP: sr.IP, Port: laddr.Port},
+		Signer:     t.opts.Signer,
+		SessionKey: sessionKey,
+		IV:         iv,
+	}
+	// Copy the public key and relay tag over to the message
+	copy(sc.Y[:], priv.Bytes())
+	binary.BigEndian.PutUint32(sc.RelayTag[:], relayTag)
+	// Marshal it
+	scb, err := sc.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	// Embed it into a datagram using our intro key
+	scd := &datagram{
+		Flag:    composeFlag(payloadSessionCreated, false, false),
+		Time:    uint32(time.Now().Unix()),
+		IV:      iv,
+		Payload: scb,
+	}
+	scdb, err := scd.MarshalBinary(t.opts.Introkey, t.opts.Introkey)
+	if err != nil {
+		return nil, err
+	}
+	// Send it
+	_, err = dc.Write(scdb)
+	if err != nil {
+		return nil, err
+	}
+
+	// STEP 3: Receive a Session Confirmed
+
+	// If Alice resends her Session Request, our Session Created was lost: resend it
+	resendCreated := func(b []byte) {
+		d := new(datagram)
+		if d.unmarshal(b, t.opts.Introkey, t.opts.Introkey) != nil {
+			return
+		}
+		if p, _, _ := decomposeFlag(d.Flag); p != payloadSessionRequest {
+			return
+		}
+		dup := new(sessionRequest)
+		if dup.UnmarshalBinary(d.Payload) == nil && dup.X == sr.X {
+			dc.Write(scdb)
+		}
+	}
+	// It is encrypted with the newly established keys, and may be fragmented
+	reassembler := new(sessionConfirmedReassembler)
+	for done := false; !done; {
+		scfd, err := readDatagramOr(ctx, dc, payloadSessionConfirmed, macKey, sessionKey, resendCreated)
+		if err != nil {
+			return nil, err
+		}
+		done, err = reassembler.add(scfd.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Reassemble it
+	scf, err := reassembler.sessionConfirmed()
+	if err != nil {
+		return nil, err
+	}
+	// Alice's identity specifies how to check the signature
+	verifier, err := identityVerifier(scf.Identity)
+	if err != nil {
+		return nil, err
+	}
+	// And check it
+	data := sessionSignedData(&sr.X, &sc.Y, aliceIP, uint16(raddr.Port), sr.IP, uint16(laddr.Port), &sc.RelayTag, time.Unix(int64(scf.SignedOn), 0))
+	if !verifier.Verify(data, scf.Signature) {
+		return nil, errors.New("invalid signature in session confirmed")
+	}
+
+	// The session is established, which we tell Alice with an empty data message so that she stops resending her
+	// Session Confirmed
+	stop()
+	conn = newConn(dc, false, sessionKey, macKey, nil, t.sessionControl, t.opts)
+	conn.relayTag = relayTag
+	conn.writeData(new(dataMessage))
+	return conn, nil
+}
@@ -0,0 +1,252 @@
+package ssu
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDialer creates a Dialer with a fresh DSA-SHA1 RouterIdentity and intro key
+func newTestDialer(t *testing.T) *Dialer {
+	return newTestDialerWithSigType(t, sigTypeDSASHA1)
+}
+
+// newTestDialerWithSigType creates a Dialer with a fresh RouterIdentity of the given signature type and intro key
+func newTestDialerWithSigType(t *testing.T, st sigType) *Dialer {
+	// Create the signer & identity
+	signer, identity := newTestSigner(t, st)
+
+	// Create the intro key
+	introKey := make([]byte, sessionKeySize)
+	_, err := rand.Read(introKey)
+	if err != nil {
+		t.Fatalf("couldn't generate intro key: %v", err)
+	}
+
+	return &Dialer{
+		RouterIdentity: identity,
+		Signer:         signer,
+		Introkey:       introKey,
+	}
+}
+
+// TestListener_Handshake tests a full session establishment between a Dialer and a Listener
+func TestListener_Handshake(t *testing.T) {
+	testHandshake(t, newTestDialer(t), newTestDialer(t))
+}
+
+// TestListener_SigTypes tests session establishments between peers of every signature type
+func TestListener_SigTypes(t *testing.T) {
+	for _, bobType := range testSigTypes {
+		for _, aliceType := range testSigTypes {
+			testHandshake(t, newTestDialerWithSigType(t, bobType), newTestDialerWithSigType(t, aliceType))
+		}
+	}
+}
+
+// TestListener_FragmentedIdentity tests a session establishment where Alice's identity spans several SessionConfirmed
+func TestListener_FragmentedIdentity(t *testing.T) {
+	alice := newTestDialer(t)
+	identity := newTestIdentity(t, 1200)
+	copy(identity, alice.RouterIdentity[:identityPublicKeySize+identitySigningKeySize])
+	alice.RouterIdentity = identity
+
+	testHandshake(t, newTestDialer(t), alice)
+}
+
+// testHandshake establishes a session from alice to bob, and checks that both sides agree on the keys
+func testHandshake(t *testing.T, bob *Dialer, alice *Dialer) {
+	aliceConn, bobConn := newTestConnPair(t, bob, alice)
+
+	// Check that both sides agree on the keys
+	aliceKeys, bobKeys := aliceConn.keys.keys(), bobConn.keys.keys()
+	if string(aliceKeys.sessionKey) != string(bobKeys.sessionKey) {
+		t.Errorf("session keys differ: %v != %v", aliceKeys.sessionKey, bobKeys.sessionKey)
+	}
+	if string(aliceKeys.macKey) != string(bobKeys.macKey) {
+		t.Errorf("mac keys differ: %v != %v", aliceKeys.macKey, bobKeys.macKey)
+	}
+}
+
+func TestListener_WrongIdentity(t *testing.T) {
+	bob := newTestDialer(t)
+	alice := newTestDialer(t)
+	mallory := newTestDialer(t)
+
+	// Listen
+	l, err := Listen("udp4", "127.0.0.1:0", bob)
+	if err != nil {
+		t.Fatalf("error in Listen: %v", err)
+	}
+	defer l.Close()
+
+	// Dial, expecting Mallory's identity
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := alice.Dial(ctx, l.Addr().(*net.UDPAddr), bob.Introkey, mallory.RouterIdentity)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("Dial succeeded with the wrong identity")
+	}
+}
+
+// lossyConn is a net.Conn losing its first datagrams in each direction, after skipping some of them
+type lossyConn struct {
+	net.Conn
+
+	mu                    sync.Mutex
+	skipWrites, skipReads int
+	dropWrites, dropReads int
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	drop := c.skipWrites == 0 && c.dropWrites > 0
+	if c.skipWrites > 0 {
+		c.skipWrites--
+	} else if drop {
+		c.dropWrites--
+	}
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *lossyConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			return n, err
+		}
+		c.mu.Lock()
+		drop := c.skipReads == 0 && c.dropReads > 0
+		if c.skipReads > 0 {
+			c.skipReads--
+		} else if drop {
+			c.dropReads--
+		}
+		c.mu.Unlock()
+		if !drop {
+			return n, nil
+		}
+	}
+}
+
+// TestListener_Lossy tests session establishments where the first SessionRequest, SessionCreated, SessionConfirmed or
+// answer to it is lost
+func TestListener_Lossy(t *testing.T) {
+	var tests = []struct {
+		desc                  string
+		skipWrites, skipReads int
+		dropWrites, dropReads int
+	}{
+		{"session request lost", 0, 0, 1, 0},
+		{"session created lost", 0, 0, 0, 1},
+		{"session confirmed lost", 1, 0, 1, 0},
+		{"session confirmed answer lost", 0, 1, 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			bob := newTestDialer(t)
+			l, err := Listen("udp4", "127.0.0.1:0", bob)
+			if err != nil {
+				t.Fatalf("error in Listen: %v", err)
+			}
+			defer l.Close()
+			accepted := make(chan *Conn, 1)
+			go func() {
+				if conn, err := l.AcceptSSU(); err == nil {
+					accepted <- conn
+				}
+			}()
+
+			// Dial over a connection losing datagrams
+			raddr := l.Addr().(*net.UDPAddr)
+			udp, err := net.DialUDP("udp4", nil, raddr)
+			if err != nil {
+				t.Fatalf("error in DialUDP: %v", err)
+			}
+			lossy := &lossyConn{
+				Conn:       udp,
+				skipWrites: test.skipWrites,
+				skipReads:  test.skipReads,
+				dropWrites: test.dropWrites,
+				dropReads:  test.dropReads,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := newTestDialer(t).DialOverConn(ctx, lossy, raddr, bob.Introkey, bob.RouterIdentity)
+			if err != nil {
+				t.Fatalf("error in DialOverConn: %v", err)
+			}
+			defer conn.Close()
+			var bobConn *Conn
+			select {
+			case bobConn = <-accepted:
+			case <-ctx.Done():
+				t.Fatal("session not accepted")
+			}
+			defer bobConn.Close()
+
+			// The session works both ways
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				t.Fatalf("error in Write: %v", err)
+			}
+			if got := readMessage(t, bobConn); string(got) != "hello" {
+				t.Errorf("got %q", got)
+			}
+			if _, err := bobConn.Write([]byte("world")); err != nil {
+				t.Fatalf("error in Write: %v", err)
+			}
+			if got := readMessage(t, conn); string(got) != "world" {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
+
+// TestListener_NetListener tests a Transport used as a net.Listener
+func TestListener_NetListener(t *testing.T) {
+	bob := newTestDialer(t)
+	var l net.Listener
+	l, err := Listen("udp4", "127.0.0.1:0", bob)
+	if err != nil {
+		t.Fatalf("error in Listen: %v", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("error in Accept: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	aliceConn, err := newTestDialer(t).Dial(ctx, l.Addr().(*net.UDPAddr), bob.Introkey, bob.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in Dial: %v", err)
+	}
+	defer aliceConn.Close()
+
+	bobConn, ok := (<-accepted).(*Conn)
+	if !ok {
+		t.Fatal("accepted connection is not a *Conn")
+	}
+	defer bobConn.Close()
+	if _, err := aliceConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	if got := readMessage(t, bobConn); string(got) != "hello" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package ssu
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// Lengths of the standard I2NP header, and of the short one used by SSU
+	standardHeaderLen = 1 + 4 + 8 + 2 + 1
+	shortHeaderLen    = 1 + 4
+
+	// maxMessagePayloadSize is the largest payload whose size fits in the standard I2NP header
+	maxMessagePayloadSize = 1<<16 - 1
+
+	// defaultMessageLifetime is the lifetime of a message written without an expiration
+	defaultMessageLifetime = time.Minute
+)
+
+/*
+A Message is an I2NP message, sent or received whole over a session
+
+In the standard I2NP header, used outside SSU, the expiration is in milliseconds and the checksum is the first byte of
+the SHA-256 hash of the payload:
+
+	+----+----+----+----+----+----+----+----+
+	|type|      msg_id       |  expiration
+	+----+----+----+----+----+----+----+----+
+	                         |  size   |chks|
+	+----+----+----+----+----+----+----+----+
+
+Over SSU the messages use a short header, the I2NP message ID being the SSU message ID, the size being given by the
+fragments and the checksum being unneeded as the datagrams are authenticated. The expiration is in seconds:
+
+	+----+----+----+----+----+
+	|type|    expiration     |
+	+----+----+----+----+----+
+*/
+type Message struct {
+	Type byte
+
+	// ID of the message, which must be random as the receivers use it to drop duplicates
+	// It is never 0 for the messages sent over SSU.
+	ID uint32
+
+	// Expiration of the message, which the short header truncates to the second
+	Expiration time.Time
+
+	Payload []byte
+}
+
+// MarshalBinary marshals the message with the standard I2NP header
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if len(m.Payload) > maxMessagePayloadSize {
+		return nil, errors.New("message payload too large")
+	}
+
+	b := make([]byte, standardHeaderLen+len(m.Payload))
+	b[0] = m.Type
+	binary.BigEndian.PutUint32(b[1:5], m.ID)
+	binary.BigEndian.PutUint64(b[5:13], uint64(m.Expiration.UnixMilli()))
+	binary.BigEndian.PutUint16(b[13:15], uint16(len(m.Payload)))
+	sum := sha256.Sum256(m.Payload)
+	b[15] = sum[0]
+	copy(b[standardHeaderLen:], m.Payload)
+
+	return b, nil
+}
+
+// UnmarshalBinary unmarshals a message with the standard I2NP header, checking its size and checksum
+// Does not retain b
+func (m *Message) UnmarshalBinary(b []byte) error {
+	if len(b) < standardHeaderLen {
+		return errors.New("message is invalid: too small")
+	}
+	size := int(binary.BigEndian.Uint16(b[13:15]))
+	if len(b) < standardHeaderLen+size {
+		return fmt.Errorf("message is invalid: size is %d but only %d bytes follow the header", size, len(b)-standardHeaderLen)
+	}
+	payload := b[standardHeaderLen : standardHeaderLen+size]
+	if sum := sha256.Sum256(payload); sum[0] != b[15] {
+		return errors.New("message is invalid: checksum mismatch")
+	}
+
+	m.Type = b[0]
+	m.ID = binary.BigEndian.Uint32(b[1:5])
+	m.Expiration = time.UnixMilli(int64(binary.BigEndian.Uint64(b[5:13])))
+	m.Payload = make([]byte, size)
+	copy(m.Payload, payload)
+
+	return nil
+}
+
+// marshalShort marshals the message with the short header used by SSU, without its ID which is the SSU message ID
+func (m *Message) marshalShort() []byte {
+	b := make([]byte, shortHeaderLen+len(m.Payload))
+	b[0] = m.Type
+	binary.BigEndian.PutUint32(b[1:5], uint32(m.Expiration.Unix()))
+	copy(b[shortHeaderLen:], m.Payload)
+	return b
+}
+
+// unmarshalShort unmarshals a message with the short header used by SSU, received with the given SSU message ID
+// Retains b
+func (m *Message) unmarshalShort(id uint32, b []byte) error {
+	if len(b) < shortHeaderLen {
+		return errors.New("message is invalid: too small for its short header")
+	}
+
+	m.Type = b[0]
+	m.ID = id
+	m.Expiration = time.Unix(int64(binary.BigEndian.Uint32(b[1:5])), 0)
+	m.Payload = b[shortHeaderLen:]
+
+	return nil
+}
+
+// ReadMessage reads the next message received over the session, keeping its boundaries, as Read does with a
+// message's short header included
+// Messages too small for a short header are dropped and reported to the OnDrop callback of the Dialer.
+// The remainder of a message partially read by Read is left to it. As with Read, it fails once the read deadline is
+// exceeded.
+func (conn *Conn) ReadMessage(ctx context.Context) (*Message, error) {
+	for {
+		if isClosedChan(conn.readDeadline.wait()) {
+			return nil, os.ErrDeadlineExceeded
+		}
+
+		select {
+		case received := <-conn.inbound:
+			msg := new(Message)
+			if err := msg.unmarshalShort(received.id, received.data); err != nil {
+				conn.opts.dropped(conn.RemoteAddr(), err)
+				continue
+			}
+			return msg, nil
+		case <-conn.closed:
+			return nil, conn.err
+		case <-conn.readDeadline.wait():
+			return nil, os.ErrDeadlineExceeded
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WriteMessage sends a message with the short header, returning once it has been acknowledged by the peer
+// The zero ID stands for none, the message being sent with a random ID drawn for each call: as the spec requires random
+// IDs, no message is sent with the ID 0. Likewise the zero expiration stands for a minute from now. msg is not modified.
+// If ctx is done or the write deadline exceeded before the acknowledgment, the message is abandoned, though it may still
+// be delivered.
+func (conn *Conn) WriteMessage(ctx context.Context, msg *Message) error {
+	m := *msg
+	for m.ID == 0 {
+		id, err := randomMessageID()
+		if err != nil {
+			return err
+		}
+		m.ID = id
+	}
+	if m.Expiration.IsZero() {
+		m.Expiration = time.Now().Add(defaultMessageLifetime)
+	}
+
+	return conn.writeMessage(ctx, m.ID, m.marshalShort())
+}
@@ -0,0 +1,105 @@
+package ssu
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMessage_MarshallingCoherence(t *testing.T) {
+	msg := &Message{
+		Type:       20,
+		ID:         0xdeadbeef,
+		Expiration: time.UnixMilli(time.Now().UnixMilli()),
+		Payload:    []byte("some I2NP payload"),
+	}
+
+	// Standard header
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	if len(b) != standardHeaderLen+len(msg.Payload) {
+		t.Errorf("got %d bytes, expected %d", len(b), standardHeaderLen+len(msg.Payload))
+	}
+	got := new(Message)
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("error in UnmarshalBinary: %v", err)
+	}
+	if got.Type != msg.Type || got.ID != msg.ID || !got.Expiration.Equal(msg.Expiration) || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Errorf("got %+v, expected %+v", got, msg)
+	}
+
+	// A corrupted payload is detected
+	b[len(b)-1] ^= 1
+	if err := got.UnmarshalBinary(b); err == nil {
+		t.Error("checksum mismatch not detected")
+	}
+
+	// Short header, truncating the expiration
+	got = new(Message)
+	if err := got.unmarshalShort(msg.ID, msg.marshalShort()); err != nil {
+		t.Fatalf("error in unmarshalShort: %v", err)
+	}
+	if got.Type != msg.Type || got.ID != msg.ID || !got.Expiration.Equal(msg.Expiration.Truncate(time.Second)) || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Errorf("got %+v, expected %+v", got, msg)
+	}
+}
+
+func TestConn_ReadWriteMessage(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Messages keep their header and boundaries
+	msg := &Message{Type: 2, ID: 42, Expiration: time.Unix(time.Now().Unix()+30, 0), Payload: make([]byte, 3000)}
+	if err := aliceConn.WriteMessage(ctx, msg); err != nil {
+		t.Fatalf("error in WriteMessage: %v", err)
+	}
+	got, err := bobConn.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("error in ReadMessage: %v", err)
+	}
+	if got.Type != msg.Type || got.ID != msg.ID || !got.Expiration.Equal(msg.Expiration) || !bytes.Equal(got.Payload, msg.Payload) {
+		t.Errorf("got %+v, expected %+v", got, msg)
+	}
+
+	// A message without ID nor expiration is given ones each time it is sent, and left as is
+	msg = &Message{Type: 1, Payload: []byte("first")}
+	var ids []uint32
+	for i := 0; i < 2; i++ {
+		if err := aliceConn.WriteMessage(ctx, msg); err != nil {
+			t.Fatalf("error in WriteMessage: %v", err)
+		}
+		got, err := bobConn.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("error in ReadMessage: %v", err)
+		}
+		if got.ID == 0 || !got.Expiration.After(time.Now()) || string(got.Payload) != "first" {
+			t.Errorf("got %+v", got)
+		}
+		ids = append(ids, got.ID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("the same ID %d was reused", ids[0])
+	}
+	if msg.ID != 0 || !msg.Expiration.IsZero() {
+		t.Errorf("message modified: %+v", msg)
+	}
+
+	// Read still sees the short header
+	if err := bobConn.WriteMessage(ctx, &Message{Type: 3, Payload: []byte("raw")}); err != nil {
+		t.Fatalf("error in WriteMessage: %v", err)
+	}
+	if got := readMessage(t, aliceConn); len(got) != shortHeaderLen+3 || got[0] != 3 {
+		t.Errorf("got %x", got)
+	}
+
+	// Reading gives up once the context is done
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := bobConn.ReadMessage(short); err != context.DeadlineExceeded {
+		t.Errorf("expected the context to expire, got %v", err)
+	}
+}
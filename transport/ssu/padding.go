@@ -0,0 +1,85 @@
+package ssu
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+// A PaddingPolicy chooses the size of the datagrams of a session, so as to hide the size of their payload
+// Whatever the size chosen, a datagram is never shorter than its content padded to the next 16-byte boundary, nor
+// larger than the session's MTU unless its content is.
+type PaddingPolicy interface {
+	// DatagramSize returns the size of a datagram whose content padded to the next 16-byte boundary is n bytes long,
+	// mtu being the largest size allowed
+	DatagramSize(n int, mtu int) int
+}
+
+// NoPadding only pads datagrams to the next 16-byte boundary, as required by the encryption
+// It is the policy used if none is given.
+type NoPadding struct{}
+
+// DatagramSize returns n, the content padded to the next 16-byte boundary
+func (NoPadding) DatagramSize(n int, mtu int) int { return n }
+
+// BlockPadding pads datagrams to a multiple of a block size
+type BlockPadding struct {
+	Size int
+}
+
+// DatagramSize returns n rounded up to a multiple of the block size, or n if it isn't positive
+func (p BlockPadding) DatagramSize(n int, mtu int) int {
+	if p.Size <= 0 {
+		return n
+	}
+	return (n + p.Size - 1) / p.Size * p.Size
+}
+
+// RandomPadding pads datagrams to a size chosen uniformly between their minimal size and the MTU
+type RandomPadding struct{}
+
+// DatagramSize returns a random size between n and mtu, or n if it doesn't fit
+func (RandomPadding) DatagramSize(n int, mtu int) int {
+	if mtu <= n {
+		return n
+	}
+	extra, err := rand.Int(rand.Reader, big.NewInt(int64(mtu-n+1)))
+	if err != nil {
+		return n
+	}
+	return n + int(extra.Int64())
+}
+
+// BucketPadding pads datagrams to the smallest of a fixed set of sizes which fits them, or to the MTU if none does
+type BucketPadding struct {
+	Sizes []int
+}
+
+// DatagramSize returns the smallest size of the set which fits n, or mtu if none does
+func (p BucketPadding) DatagramSize(n int, mtu int) int {
+	best := 0
+	for _, size := range p.Sizes {
+		if size >= n && (best == 0 || size < best) {
+			best = size
+		}
+	}
+	if best == 0 {
+		return mtu
+	}
+	return best
+}
+
+// paddedSize applies a padding policy to a datagram, bounding the size it chooses
+func paddedSize(policy PaddingPolicy, d *datagram, mtu int) int {
+	n := d.minLen()
+	if policy == nil {
+		return n
+	}
+	size := policy.DatagramSize(n, mtu)
+	if size > mtu {
+		size = mtu
+	}
+	if size < n {
+		size = n
+	}
+	return size
+}
@@ -0,0 +1,97 @@
+package ssu
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPaddingPolicies(t *testing.T) {
+	const mtu = ipv4MTU
+
+	var tests = []struct {
+		desc     string
+		policy   PaddingPolicy
+		n        int
+		min, max int // expected bounds of the size
+	}{
+		{"no policy", nil, 64, 64, 64},
+		{"none", NoPadding{}, 64, 64, 64},
+		{"block", BlockPadding{Size: 256}, 64, 256, 256},
+		{"block of an exact multiple", BlockPadding{Size: 256}, 512, 512, 512},
+		{"block beyond the MTU", BlockPadding{Size: 1024}, 1040, mtu, mtu},
+		{"random", RandomPadding{}, 64, 64, mtu},
+		{"random of a full datagram", RandomPadding{}, mtu, mtu, mtu},
+		{"bucket", BucketPadding{Sizes: []int{1024, 128, 512}}, 208, 512, 512},
+		{"above every bucket", BucketPadding{Sizes: []int{128}}, 208, mtu, mtu},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := &datagram{Payload: make([]byte, test.n-nominalHeaderLen)}
+			if d.minLen() != test.n {
+				t.Fatalf("test datagram of %d bytes instead of %d", d.minLen(), test.n)
+			}
+			for i := 0; i < 100; i++ {
+				if size := paddedSize(test.policy, d, mtu); size < test.min || size > test.max {
+					t.Fatalf("size %d not within [%d, %d]", size, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestDatagram_Size(t *testing.T) {
+	key := make([]byte, sessionKeySize)
+	payload := []byte("this is the payload")
+
+	// Padding beyond the last 16-byte block is left unencrypted, but authenticated
+	for _, size := range []int{0, 100, 101, 1000} {
+		origin := &datagram{
+			Flag:    composeFlag(payloadData, false, false),
+			Time:    uint32(time.Now().Unix()),
+			Payload: payload,
+			Size:    size,
+		}
+		b, err := origin.MarshalBinary(key, key)
+		if err != nil {
+			t.Fatalf("error in MarshalBinary: %v", err)
+		}
+		if expected := origin.minLen(); size > expected && len(b) != size {
+			t.Errorf("datagram of %d bytes instead of %d", len(b), size)
+		} else if size <= expected && len(b) != expected {
+			t.Errorf("datagram of %d bytes instead of %d", len(b), expected)
+		}
+
+		destination := new(datagram)
+		if err := destination.unmarshal(b, key, key); err != nil {
+			t.Fatalf("error in unmarshal of %d bytes: %v", len(b), err)
+		}
+		if !bytes.HasPrefix(destination.Payload, payload) {
+			t.Errorf("payload %q, expected %q", destination.Payload, payload)
+		}
+
+		b[len(b)-1] ^= 1
+		if err := destination.unmarshal(b, key, key); err == nil {
+			t.Errorf("altered padding of a %d bytes datagram not detected", len(b))
+		}
+	}
+}
+
+func TestConn_Padding(t *testing.T) {
+	for _, policy := range []PaddingPolicy{RandomPadding{}, BucketPadding{Sizes: []int{256, 1024}}, BlockPadding{Size: 100}} {
+		alice, bob := newTestDialer(t), newTestDialer(t)
+		alice.Padding, bob.Padding = policy, policy
+		aliceConn, bobConn := newTestConnPair(t, bob, alice)
+
+		for _, size := range []int{1, 1000, 5000} {
+			msg := make([]byte, size)
+			if _, err := aliceConn.Write(msg); err != nil {
+				t.Fatalf("error in Write with %T: %v", policy, err)
+			}
+			if got := readMessage(t, bobConn); !bytes.Equal(got, msg) {
+				t.Errorf("message of %d bytes altered with %T", size, policy)
+			}
+		}
+	}
+}
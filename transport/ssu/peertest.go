@@ -0,0 +1,83 @@
+package ssu
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+/*
+A peerTest is exchanged between Alice, Bob and Charlie to let Alice know whether Alice is reachable
+
++----+----+----+----+----+----+----+----+
+|    test nonce     |size| Alice IP addr
++----+----+----+----+----+----+----+----+
+     | Port (A)|                        |
++----+----+----+                        +
+| Alice or Charlie's                    |
++ introduction key (Alice's is sent to  +
+| Bob and Charlie, while Charlie's is   |
++ sent to Alice)                        +
+|                                       |
++              +----+----+----+----+----+
+|              | arbitrary amount of    |
++----+----+----+                        |
+| uninterpreted data                    |
+~                .  .  .                ~
+*/
+type peerTest struct {
+	// Nonce identifying the test
+	Nonce uint32
+
+	// Alice's address, empty with a zero port when sent by Alice
+	AliceAddr net.UDPAddr
+
+	// Alice's intro key when sent to Bob and Charlie, Charlie's when sent to Alice
+	IntroKey [introKeySize]byte
+}
+
+func (pt *peerTest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 4+1+16+2+introKeySize)
+
+	// Nonce
+	b = binary.BigEndian.AppendUint32(b, pt.Nonce)
+
+	// Alice's address
+	b, err := appendAddr(b, &pt.AliceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Intro key
+	b = append(b, pt.IntroKey[:]...)
+
+	return b, nil
+}
+
+// Does not retain b
+func (pt *peerTest) UnmarshalBinary(b []byte) error {
+	// Nonce
+	if len(b) < 4 {
+		return errTooSmall
+	}
+	pt.Nonce = binary.BigEndian.Uint32(b)
+
+	// Alice's address
+	b, err := readAddr(b[4:], &pt.AliceAddr)
+	if err != nil {
+		return err
+	}
+
+	// Intro key
+	if len(b) < introKeySize {
+		return errTooSmall
+	}
+	copy(pt.IntroKey[:], b)
+
+	// Any following data is uninterpreted
+	return nil
+}
+
+// fromAlice checks whether the message was sent by Alice, which leaves its own address empty
+func (pt *peerTest) fromAlice() bool {
+	return len(pt.AliceAddr.IP) == 0 && pt.AliceAddr.Port == 0
+}
@@ -0,0 +1,153 @@
+package ssu
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeerTest_MarshallingCoherence(t *testing.T) {
+	introKey := [introKeySize]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+
+	var tests = []struct {
+		desc     string
+		msg      *peerTest
+		expected int // expected length
+	}{
+		{
+			"from Alice",
+			&peerTest{Nonce: 42, IntroKey: introKey},
+			4 + 1 + 2 + introKeySize,
+		},
+		{
+			"with IPv4 Alice",
+			&peerTest{Nonce: 42, AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}, IntroKey: introKey},
+			4 + 1 + 4 + 2 + introKeySize,
+		},
+		{
+			"with IPv6 Alice",
+			&peerTest{Nonce: 42, AliceAddr: net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, IntroKey: introKey},
+			4 + 1 + 16 + 2 + introKeySize,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			b, err := test.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error while marshalling: %v", err)
+			}
+			if len(b) != test.expected {
+				t.Errorf("marshalled to %d bytes, expected %d", len(b), test.expected)
+			}
+
+			got := new(peerTest)
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatalf("error while unmarshalling: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.msg) {
+				t.Errorf("unmarshalled %+v, expected %+v", got, test.msg)
+			}
+
+			// Truncations are detected
+			for i := 0; i < len(b); i++ {
+				if err := new(peerTest).UnmarshalBinary(b[:i]); err == nil {
+					t.Errorf("no error when unmarshalling %d of %d bytes", i, len(b))
+				}
+			}
+		})
+	}
+}
+
+func TestPeerTests_Role(t *testing.T) {
+	pt := newPeerTests()
+	now := time.Now()
+	fromAlice := &peerTest{Nonce: 1}
+	fromBob := &peerTest{Nonce: 2, AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}}
+
+	// New tests
+	if role, state := pt.roleOf(fromAlice, now); role != peerTestBob || state != nil {
+		t.Errorf("expected to be Bob in a new test from Alice, got %v", role)
+	}
+	if role, state := pt.roleOf(fromBob, now); role != peerTestCharlie || state != nil {
+		t.Errorf("expected to be Charlie in a new test from Bob, got %v", role)
+	}
+
+	// Known tests keep their role, whatever the message
+	alice := &peerTestState{role: peerTestAlice}
+	if !pt.add(1, alice, now) {
+		t.Fatal("failed to add a test")
+	}
+	if role, state := pt.roleOf(fromAlice, now); role != peerTestAlice || state != alice {
+		t.Errorf("expected to be Alice in a known test, got %v", role)
+	}
+
+	// Until they expire
+	if role, state := pt.roleOf(fromAlice, now.Add(peerTestLifetime+time.Second)); role != peerTestBob || state != nil {
+		t.Errorf("expected the test to expire, got %v", role)
+	}
+
+	// The table is bounded
+	for i := uint32(0); i < maxPeerTests; i++ {
+		pt.add(i, &peerTestState{role: peerTestCharlie}, now)
+	}
+	if pt.add(maxPeerTests, &peerTestState{role: peerTestCharlie}, now) {
+		t.Error("added a test to a full table")
+	}
+}
+
+func TestPeerTest(t *testing.T) {
+	alice := newTestTransport(t, newTestDialer(t))
+	bob := newTestTransport(t, newTestDialer(t))
+	charlie := newTestTransport(t, newTestDialer(t))
+
+	// Both Alice and Charlie have a session with Bob
+	ab, _ := dialTransport(t, alice, bob)
+	dialTransport(t, charlie, bob)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := alice.peerTest(ctx, ab)
+	if err != nil {
+		t.Fatalf("error in peerTest: %v", err)
+	}
+
+	// As there is no NAT, both see Alice's actual address
+	addr := alice.Addr().(*net.UDPAddr)
+	if !sameAddr(addr, result.BobSaw) {
+		t.Errorf("Bob saw %v instead of %v", result.BobSaw, addr)
+	}
+	if !result.CharlieReached {
+		t.Error("Charlie didn't reach Alice")
+	}
+	if !sameAddr(addr, result.CharlieSaw) {
+		t.Errorf("Charlie saw %v instead of %v", result.CharlieSaw, addr)
+	}
+
+	// Test states are kept until they expire
+	bob.peerTests.mu.Lock()
+	n := len(bob.peerTests.tests)
+	bob.peerTests.mu.Unlock()
+	if n != 1 {
+		t.Errorf("expected Bob to have a single test, got %d", n)
+	}
+}
+
+func TestPeerTest_NoCharlie(t *testing.T) {
+	alice := newTestTransport(t, newTestDialer(t))
+	bob := newTestTransport(t, newTestDialer(t))
+	ab, _ := dialTransport(t, alice, bob)
+
+	// Bob can't find a Charlie, so the test doesn't go anywhere
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err := alice.peerTest(ctx, ab)
+	if err != nil {
+		t.Fatalf("error in peerTest: %v", err)
+	}
+	if result.BobSaw != nil || result.CharlieReached || result.CharlieSaw != nil {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,427 @@
+package ssu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Lifetime of a peer test, after which its state is forgotten
+	peerTestLifetime = 15 * time.Second
+
+	// Maximum number of tests we take part in at once as Bob or Charlie
+	maxPeerTests = 64
+
+	// Interval between the retransmissions of Alice's messages
+	peerTestInterval = time.Second
+)
+
+// peerTestRole is the part we play in a peer test
+type peerTestRole int
+
+const (
+	// Alice is the peer testing its reachability
+	peerTestAlice peerTestRole = iota
+
+	// Bob is the peer Alice asks to test it, which has sessions with both Alice and Charlie
+	peerTestBob
+
+	// Charlie is the peer chosen by Bob to contact Alice
+	peerTestCharlie
+)
+
+// peerTestState is the state of a test we take part in
+type peerTestState struct {
+	role    peerTestRole
+	expires time.Time
+
+	// Alice's address, as seen by Bob, and intro key, as Bob or Charlie
+	aliceAddr     *net.UDPAddr
+	aliceIntroKey [introKeySize]byte
+
+	// The session with Charlie, as Bob
+	charlie *Conn
+
+	// The session with Bob, as Charlie or Alice
+	bob *Conn
+
+	// Charlie's address and intro key, once Charlie contacted us, as Alice
+	charlieAddr     *net.UDPAddr
+	charlieIntroKey [introKeySize]byte
+
+	// What we learnt, as Alice
+	result peerTestResult
+
+	// Signalled whenever the result changes, as Alice
+	updated chan struct{}
+}
+
+// peerTestResult is what Alice learns from a peer test
+type peerTestResult struct {
+	// Alice's address as seen by Bob, nil if Bob didn't answer
+	BobSaw *net.UDPAddr
+
+	// Whether Charlie could reach Alice at the address seen by Bob
+	CharlieReached bool
+
+	// Alice's address as seen by Charlie when Alice answered it, nil if Charlie didn't confirm
+	CharlieSaw *net.UDPAddr
+}
+
+// complete checks whether the test has nothing more to teach Alice
+func (r *peerTestResult) complete() bool {
+	return r.BobSaw != nil && r.CharlieSaw != nil
+}
+
+// peerTests is the table of the tests we take part in, indexed by nonce
+type peerTests struct {
+	mu    sync.Mutex
+	tests map[uint32]*peerTestState
+}
+
+func newPeerTests() *peerTests {
+	return &peerTests{
+		tests: make(map[uint32]*peerTestState),
+	}
+}
+
+// expire forgets the tests past their lifetime
+// The caller must hold the lock
+func (pt *peerTests) expire(now time.Time) {
+	for nonce, state := range pt.tests {
+		if now.After(state.expires) {
+			delete(pt.tests, nonce)
+		}
+	}
+}
+
+// roleOf works out our role in the test a message belongs to, following the rules of the spec
+// If the nonce is known, so is our role. Otherwise the test is a new one, in which we are Bob if the message has no
+// address, as Alice sent it, and Charlie else. The state of a new test is nil.
+// The caller must hold the lock
+func (pt *peerTests) roleOf(msg *peerTest, now time.Time) (peerTestRole, *peerTestState) {
+	pt.expire(now)
+	if state, ok := pt.tests[msg.Nonce]; ok {
+		return state.role, state
+	}
+	if msg.fromAlice() {
+		return peerTestBob, nil
+	}
+	return peerTestCharlie, nil
+}
+
+// add records a new test as Bob or Charlie, failing if there are too many of them
+// The caller must hold the lock
+func (pt *peerTests) add(nonce uint32, state *peerTestState, now time.Time) bool {
+	if len(pt.tests) >= maxPeerTests {
+		return false
+	}
+	state.expires = now.Add(peerTestLifetime)
+	pt.tests[nonce] = state
+	return true
+}
+
+// sameAddr checks whether a remote address is the given UDP address
+func sameAddr(addr net.Addr, udp *net.UDPAddr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	return ok && udp != nil && a.IP.Equal(udp.IP) && a.Port == udp.Port
+}
+
+// peerTest runs a test of our reachability through Bob, with whom we have a session, acting as Alice
+// It returns once the test completes, or with what was learnt so far once ctx is done or the test expires.
+/*
+       Alice                     Bob                  Charlie
+   PeerTest ------------------->
+                                PeerTest-------------------->
+                                   <-------------------PeerTest
+        <-------------------PeerTest
+        <------------------------------------------PeerTest
+   PeerTest------------------------------------------>
+        <------------------------------------------PeerTest
+*/
+func (t *Transport) peerTest(ctx context.Context, bob *Conn) (*peerTestResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerTestLifetime)
+	defer cancel()
+
+	// Register the test under a new nonce
+	state := &peerTestState{
+		role:    peerTestAlice,
+		bob:     bob,
+		updated: make(chan struct{}, 1),
+	}
+	nonce, err := t.registerPeerTest(state)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		t.peerTests.mu.Lock()
+		delete(t.peerTests.tests, nonce)
+		t.peerTests.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(peerTestInterval)
+	defer ticker.Stop()
+	for {
+		// Send the messages still unanswered
+		t.peerTests.mu.Lock()
+		result := state.result
+		charlieAddr, charlieIntroKey := state.charlieAddr, state.charlieIntroKey
+		t.peerTests.mu.Unlock()
+		if result.complete() {
+			return &result, nil
+		}
+		msg := &peerTest{Nonce: nonce}
+		copy(msg.IntroKey[:], t.opts.Introkey)
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if result.BobSaw == nil {
+			if err := bob.writeControl(payloadPeerTest, b); err != nil {
+				return nil, err
+			}
+		}
+		if charlieAddr != nil && result.CharlieSaw == nil {
+			if err := t.writeIntroKeyed(charlieAddr, charlieIntroKey[:], payloadPeerTest, b); err != nil {
+				return nil, err
+			}
+		}
+
+		// Wait for news
+		select {
+		case <-state.updated:
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.peerTests.mu.Lock()
+			result = state.result
+			t.peerTests.mu.Unlock()
+			return &result, nil
+		case <-t.closed:
+			return nil, t.err
+		}
+	}
+}
+
+// registerPeerTest records a test we start as Alice under a new nonce
+func (t *Transport) registerPeerTest(state *peerTestState) (uint32, error) {
+	t.peerTests.mu.Lock()
+	defer t.peerTests.mu.Unlock()
+
+	state.expires = time.Now().Add(peerTestLifetime)
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		nonce := binary.BigEndian.Uint32(b[:])
+		if _, ok := t.peerTests.tests[nonce]; !ok {
+			t.peerTests.tests[nonce] = state
+			return nonce, nil
+		}
+	}
+}
+
+// handlePeerTest handles a peer test message, playing whichever role it gives us
+// conn is the session it was received over, or nil if it used our intro key
+func (t *Transport) handlePeerTest(addr net.Addr, conn *Conn, payload []byte) {
+	msg := new(peerTest)
+	if err := msg.UnmarshalBinary(payload); err != nil {
+		return
+	}
+
+	t.peerTests.mu.Lock()
+	role, state := t.peerTests.roleOf(msg, time.Now())
+	t.peerTests.mu.Unlock()
+
+	switch role {
+	case peerTestAlice:
+		t.peerTestAsAlice(addr, msg, state)
+	case peerTestBob:
+		t.peerTestAsBob(addr, conn, msg, state)
+	case peerTestCharlie:
+		t.peerTestAsCharlie(addr, conn, msg, state)
+	}
+}
+
+// peerTestAsAlice records the answers to a test we started
+func (t *Transport) peerTestAsAlice(addr net.Addr, msg *peerTest, state *peerTestState) {
+	aliceAddr := &net.UDPAddr{IP: msg.AliceAddr.IP, Port: msg.AliceAddr.Port}
+	from, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	bobAddr, _ := state.bob.RemoteAddr().(*net.UDPAddr)
+
+	t.peerTests.mu.Lock()
+	defer t.peerTests.mu.Unlock()
+
+	switch {
+	case sameAddr(from, bobAddr):
+		// Bob tells us the address it sees
+		if state.result.BobSaw != nil {
+			return
+		}
+		state.result.BobSaw = aliceAddr
+	case state.charlieAddr == nil:
+		// Charlie reached us
+		state.charlieAddr = from
+		state.charlieIntroKey = msg.IntroKey
+		state.result.CharlieReached = true
+	case sameAddr(from, state.charlieAddr):
+		// Charlie answered us, telling us the address it sees
+		if state.result.CharlieSaw != nil {
+			return
+		}
+		state.result.CharlieSaw = aliceAddr
+	default:
+		return
+	}
+
+	select {
+	case state.updated <- struct{}{}:
+	default:
+	}
+}
+
+// peerTestAsBob relays a test between Alice and Charlie
+// A new test must come from Alice over an established session. We then pick as Charlie another peer with whom we have
+// a session over the same IP family, and forward it Alice's address and intro key. Charlie's answer is relayed to
+// Alice using Alice's intro key.
+func (t *Transport) peerTestAsBob(addr net.Addr, conn *Conn, msg *peerTest, state *peerTestState) {
+	now := time.Now()
+
+	if state == nil {
+		// Alice must have a session with us
+		if conn == nil {
+			return
+		}
+		aliceAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			return
+		}
+		charlie := t.pickCharlie(aliceAddr)
+		if charlie == nil {
+			return
+		}
+
+		state = &peerTestState{
+			role:          peerTestBob,
+			aliceAddr:     &net.UDPAddr{IP: shortIP(aliceAddr.IP), Port: aliceAddr.Port},
+			aliceIntroKey: msg.IntroKey,
+			charlie:       charlie,
+		}
+		t.peerTests.mu.Lock()
+		ok = t.peerTests.add(msg.Nonce, state, now)
+		t.peerTests.mu.Unlock()
+		if !ok {
+			return
+		}
+	}
+
+	switch {
+	case sameAddr(addr, state.aliceAddr):
+		// Forward Alice's request to Charlie, again if it is a retransmission
+		fwd := &peerTest{
+			Nonce:     msg.Nonce,
+			AliceAddr: *state.aliceAddr,
+			IntroKey:  state.aliceIntroKey,
+		}
+		b, err := fwd.MarshalBinary()
+		if err != nil {
+			return
+		}
+		state.charlie.writeControl(payloadPeerTest, b)
+	case conn != nil && conn == state.charlie:
+		// Relay Charlie's answer to Alice, with Charlie's intro key
+		resp := &peerTest{
+			Nonce:     msg.Nonce,
+			AliceAddr: *state.aliceAddr,
+			IntroKey:  msg.IntroKey,
+		}
+		b, err := resp.MarshalBinary()
+		if err != nil {
+			return
+		}
+		t.writeIntroKeyed(state.aliceAddr, state.aliceIntroKey[:], payloadPeerTest, b)
+	}
+}
+
+// pickCharlie chooses the peer to contact Alice in a test, among the ones with whom we have a session over the IP
+// family of Alice's address, returning nil if there is none
+func (t *Transport) pickCharlie(alice *net.UDPAddr) *Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, r := range t.routes {
+		if r.conn == nil {
+			continue
+		}
+		addr, ok := r.conn.RemoteAddr().(*net.UDPAddr)
+		if !ok || sameAddr(addr, alice) || (addr.IP.To4() == nil) != (alice.IP.To4() == nil) {
+			continue
+		}
+		return r.conn
+	}
+	return nil
+}
+
+// peerTestAsCharlie contacts Alice on behalf of Bob, and answers Alice
+// A new test must come from Bob over an established session. We answer Bob with our intro key, and send it to Alice
+// at the address seen by Bob, using Alice's intro key. If Alice can receive it, Alice answers us and we confirm,
+// telling Alice the address we see it from, which differs from Bob's view behind a symmetric NAT.
+func (t *Transport) peerTestAsCharlie(addr net.Addr, conn *Conn, msg *peerTest, state *peerTestState) {
+	now := time.Now()
+
+	if state == nil {
+		// Bob must have a session with us
+		if conn == nil || msg.AliceAddr.Port == 0 {
+			return
+		}
+		if ip := msg.AliceAddr.IP; ip.To4() == nil && len(ip) != net.IPv6len {
+			return
+		}
+
+		state = &peerTestState{
+			role:          peerTestCharlie,
+			aliceAddr:     &net.UDPAddr{IP: msg.AliceAddr.IP, Port: msg.AliceAddr.Port},
+			aliceIntroKey: msg.IntroKey,
+			bob:           conn,
+		}
+		t.peerTests.mu.Lock()
+		ok := t.peerTests.add(msg.Nonce, state, now)
+		t.peerTests.mu.Unlock()
+		if !ok {
+			return
+		}
+	}
+
+	// Every message we send carries Alice's address and our intro key
+	resp := &peerTest{Nonce: msg.Nonce}
+	copy(resp.IntroKey[:], t.opts.Introkey)
+
+	switch from, ok := addr.(*net.UDPAddr); {
+	case conn != nil && conn == state.bob:
+		// Answer Bob, and contact Alice at the address seen by Bob, again if it is a retransmission
+		resp.AliceAddr = *state.aliceAddr
+		b, err := resp.MarshalBinary()
+		if err != nil {
+			return
+		}
+		if err := conn.writeControl(payloadPeerTest, b); err != nil {
+			return
+		}
+		t.writeIntroKeyed(state.aliceAddr, state.aliceIntroKey[:], payloadPeerTest, b)
+	case conn == nil && ok:
+		// Alice reached us back, possibly from another address
+		resp.AliceAddr = net.UDPAddr{IP: shortIP(from.IP), Port: from.Port}
+		b, err := resp.MarshalBinary()
+		if err != nil {
+			return
+		}
+		t.writeIntroKeyed(from, state.aliceIntroKey[:], payloadPeerTest, b)
+	}
+}
@@ -0,0 +1,186 @@
+package ssu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// NATStatus tells whether peers can reach us directly over an IP family
+type NATStatus int
+
+const (
+	// StatusUnknown is reported until a peer test gives a conclusive result
+	StatusUnknown NATStatus = iota
+
+	// StatusOK means that peers can contact us directly at our external address
+	StatusOK
+
+	// StatusFirewalled means that peers can't contact us unless we contacted them first, so we need introducers
+	StatusFirewalled
+
+	// StatusSymmetricNAT means that our external port changes with the peer we talk to, so we can't be reached
+	// even through introducers
+	StatusSymmetricNAT
+)
+
+func (s NATStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusFirewalled:
+		return "Firewalled"
+	case StatusSymmetricNAT:
+		return "Symmetric-NAT"
+	default:
+		return "Unknown"
+	}
+}
+
+// ExternalAddr is our reachability over an IP family, as observed by our peers
+type ExternalAddr struct {
+	Status NATStatus
+
+	// Our address as seen by the peers, nil if unknown
+	Addr *net.UDPAddr
+}
+
+// Reachability is our reachability over each IP family
+type Reachability struct {
+	IPv4 ExternalAddr
+	IPv6 ExternalAddr
+}
+
+// statusOf interprets the result of a peer test
+// It returns false if the test was inconclusive, as Bob didn't answer
+func statusOf(result *peerTestResult) (ExternalAddr, bool) {
+	if result.BobSaw == nil {
+		return ExternalAddr{}, false
+	}
+
+	ext := ExternalAddr{Addr: result.BobSaw}
+	switch {
+	case result.CharlieSaw != nil && !sameAddr(result.CharlieSaw, result.BobSaw):
+		// Our address changes with the peer
+		ext.Status = StatusSymmetricNAT
+	case result.CharlieReached:
+		// Charlie could reach us without being contacted first
+		ext.Status = StatusOK
+	default:
+		ext.Status = StatusFirewalled
+	}
+	return ext, true
+}
+
+// reachability holds our current reachability, and the channels of the subscribers to its changes
+type reachability struct {
+	mu          sync.Mutex
+	current     Reachability
+	subscribers map[chan Reachability]struct{}
+	closed      bool
+}
+
+func newReachability() *reachability {
+	return &reachability{
+		subscribers: make(map[chan Reachability]struct{}),
+	}
+}
+
+// get returns the current reachability
+func (r *reachability) get() Reachability {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// update records our reachability over the family of the given address, notifying the subscribers if it changed
+func (r *reachability) update(ipv4 bool, ext ExternalAddr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family := &r.current.IPv6
+	if ipv4 {
+		family = &r.current.IPv4
+	}
+	if family.Status == ext.Status && sameAddr(ext.Addr, family.Addr) {
+		return
+	}
+	*family = ext
+
+	// Notify the subscribers, replacing the change they didn't receive yet if any
+	for ch := range r.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- r.current
+	}
+}
+
+// subscribe returns a channel receiving the changes, and the function to stop receiving them
+func (r *reachability) subscribe() (<-chan Reachability, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan Reachability, 1)
+	if r.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	r.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if _, ok := r.subscribers[ch]; ok {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close closes the channels of all the subscribers
+func (r *reachability) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	for ch := range r.subscribers {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Reachability returns our reachability over each IP family, as found by the last conclusive peer tests
+func (t *Transport) Reachability() Reachability {
+	return t.reachability.get()
+}
+
+// SubscribeReachability returns a channel receiving our reachability whenever it changes, and a function to
+// unsubscribe. Only the latest change is kept for a slow receiver.
+// The channel is closed once unsubscribed or once the Transport is closed.
+func (t *Transport) SubscribeReachability() (<-chan Reachability, func()) {
+	return t.reachability.subscribe()
+}
+
+// TestReachability runs a peer test through a session established over the Transport, updating our reachability
+// over the IP family of the session
+// Our reachability is unchanged if Bob doesn't answer before ctx is done.
+func (t *Transport) TestReachability(ctx context.Context, bob *Conn) error {
+	addr, ok := bob.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return errors.New("remote address is not an UDP address")
+	}
+
+	result, err := t.peerTest(ctx, bob)
+	if err != nil {
+		return err
+	}
+	if ext, ok := statusOf(result); ok {
+		t.reachability.update(addr.IP.To4() != nil, ext)
+	}
+	return nil
+}
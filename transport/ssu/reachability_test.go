@@ -0,0 +1,130 @@
+package ssu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatusOf(t *testing.T) {
+	seen := &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}
+	other := &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 5678}
+
+	var tests = []struct {
+		desc       string
+		result     peerTestResult
+		status     NATStatus
+		conclusive bool
+	}{
+		{"no answer", peerTestResult{}, StatusUnknown, false},
+		{"only Bob answered", peerTestResult{BobSaw: seen}, StatusFirewalled, true},
+		{"Charlie reached us", peerTestResult{BobSaw: seen, CharlieReached: true}, StatusOK, true},
+		{"Charlie confirmed", peerTestResult{BobSaw: seen, CharlieReached: true, CharlieSaw: seen}, StatusOK, true},
+		{"Charlie saw another port", peerTestResult{BobSaw: seen, CharlieReached: true, CharlieSaw: other}, StatusSymmetricNAT, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ext, ok := statusOf(&test.result)
+			if ok != test.conclusive {
+				t.Fatalf("expected conclusiveness %t, got %t", test.conclusive, ok)
+			}
+			if ext.Status != test.status {
+				t.Errorf("expected %v, got %v", test.status, ext.Status)
+			}
+			if ok && !sameAddr(ext.Addr, seen) {
+				t.Errorf("expected external address %v, got %v", seen, ext.Addr)
+			}
+		})
+	}
+}
+
+func TestReachability_Subscribe(t *testing.T) {
+	r := newReachability()
+	ch, unsubscribe := r.subscribe()
+	addr := &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}
+
+	// A change is delivered
+	r.update(true, ExternalAddr{Status: StatusFirewalled, Addr: addr})
+	select {
+	case got := <-ch:
+		if got.IPv4.Status != StatusFirewalled || !sameAddr(got.IPv4.Addr, addr) || got.IPv6.Status != StatusUnknown {
+			t.Errorf("unexpected reachability %+v", got)
+		}
+	default:
+		t.Fatal("change not delivered")
+	}
+
+	// The same state isn't
+	r.update(true, ExternalAddr{Status: StatusFirewalled, Addr: addr})
+	select {
+	case got := <-ch:
+		t.Errorf("unexpected notification %+v", got)
+	default:
+	}
+
+	// Only the latest change is kept
+	r.update(true, ExternalAddr{Status: StatusOK, Addr: addr})
+	r.update(false, ExternalAddr{Status: StatusFirewalled})
+	if got := <-ch; got.IPv4.Status != StatusOK || got.IPv6.Status != StatusFirewalled {
+		t.Errorf("unexpected reachability %+v", got)
+	}
+	if got := r.get(); got.IPv4.Status != StatusOK || got.IPv6.Status != StatusFirewalled {
+		t.Errorf("unexpected reachability %+v", got)
+	}
+
+	// Unsubscribing closes the channel
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	unsubscribe()
+
+	// So does closing
+	ch, _ = r.subscribe()
+	r.close()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after closing")
+	}
+}
+
+func TestTransport_TestReachability(t *testing.T) {
+	alice := newTestTransport(t, newTestDialer(t))
+	bob := newTestTransport(t, newTestDialer(t))
+	charlie := newTestTransport(t, newTestDialer(t))
+	ab, _ := dialTransport(t, alice, bob)
+	dialTransport(t, charlie, bob)
+
+	ch, unsubscribe := alice.SubscribeReachability()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := alice.TestReachability(ctx, ab); err != nil {
+		t.Fatalf("error in TestReachability: %v", err)
+	}
+
+	// Without NAT, we are reachable at our own address
+	addr := alice.Addr().(*net.UDPAddr)
+	select {
+	case got := <-ch:
+		if got.IPv4.Status != StatusOK || !sameAddr(addr, got.IPv4.Addr) {
+			t.Errorf("unexpected reachability %+v", got)
+		}
+		if got.IPv6.Status != StatusUnknown || got.IPv6.Addr != nil {
+			t.Errorf("IPv6 reachability changed by an IPv4 test: %+v", got.IPv6)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	if got := alice.Reachability(); got.IPv4.Status != StatusOK {
+		t.Errorf("unexpected reachability %+v", got)
+	}
+
+	// Closing the Transport closes the subscriptions
+	alice.Close()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after closing the transport")
+	}
+}
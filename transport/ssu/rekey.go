@@ -0,0 +1,235 @@
+package ssu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// rekeyGracePeriod is how long the previous keys remain valid after a rekeying, covering reordered datagrams
+	rekeyGracePeriod = 10 * time.Second
+
+	// rekeyRetransmitInterval is the interval between the retransmissions of our keying material until the peer
+	// echoes it
+	rekeyRetransmitInterval = initialRTO
+)
+
+// sessionKeys are the keys authenticating and encrypting the datagrams of a session
+type sessionKeys struct {
+	macKey     []byte
+	sessionKey []byte
+}
+
+// keyring holds the keys of a session across rekeyings
+/*
+The initiator sends 64 bytes of keying material in a datagram with the rekey flag set, and the peer replies with the
+same keying material. Each side switches to the keys derived from it once it has both sent and received it: the peer
+when replying, the initiator when receiving the reply, or any datagram authenticated with the new keys. Until then,
+and for a grace period afterwards, the previous keys remain valid.
+*/
+type keyring struct {
+	mu sync.Mutex
+
+	// Keys we send with
+	current sessionKeys
+
+	// Keys we used before the last rekeying, still accepted until they expire
+	previous        sessionKeys
+	previousExpires time.Time
+
+	// Keying material we sent and the keys it derives, until the peer echoes it
+	pending         []byte
+	pendingKeys     sessionKeys
+	pendingAttempts int
+	pendingResendAt time.Time
+
+	// Keying material of the last rekeying, and whether we initiated it, to recognise its retransmissions
+	last          []byte
+	lastInitiated bool
+}
+
+func newKeyring(sessionKey []byte, macKey []byte) *keyring {
+	return &keyring{
+		current: sessionKeys{macKey: macKey, sessionKey: sessionKey},
+	}
+}
+
+// keys returns the keys to send with
+func (kr *keyring) keys() sessionKeys {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	return kr.current
+}
+
+// lookup returns the keys a datagram is authenticated with, among the current, pending and unexpired previous ones
+// pending is set if they are the keys derived from our keying material, meaning the peer switched to them
+func (kr *keyring) lookup(b []byte, now time.Time) (keys sessionKeys, pending bool, ok bool) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	switch {
+	case authenticDatagram(b, kr.current.macKey):
+		return kr.current, false, true
+	case kr.pending != nil && authenticDatagram(b, kr.pendingKeys.macKey):
+		return kr.pendingKeys, true, true
+	case kr.previous.macKey != nil && now.Before(kr.previousExpires) && authenticDatagram(b, kr.previous.macKey):
+		return kr.previous, false, true
+	default:
+		return sessionKeys{}, false, false
+	}
+}
+
+// start generates keying material to initiate a rekeying, returning it along with the keys to send it with
+// It returns false if a rekeying is already in progress.
+func (kr *keyring) start(now time.Time) (material []byte, keys sessionKeys, ok bool, err error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if kr.pending != nil {
+		return nil, sessionKeys{}, false, nil
+	}
+
+	// Generate the material, and derive the new keys from it
+	material = make([]byte, keyingMaterialSize)
+	if _, err := rand.Read(material); err != nil {
+		return nil, sessionKeys{}, false, err
+	}
+	macKey, sessionKey, err := keysFromKeyingMaterial(material)
+	if err != nil {
+		return nil, sessionKeys{}, false, err
+	}
+
+	kr.pending = material
+	kr.pendingKeys = sessionKeys{macKey: macKey, sessionKey: sessionKey}
+	kr.pendingAttempts = 1
+	kr.pendingResendAt = now.Add(rekeyRetransmitInterval)
+	return material, kr.current, true, nil
+}
+
+// due returns the keying material to send again if the peer hasn't echoed it in time, along with the keys to send it
+// with. After too many attempts, the rekeying is abandoned.
+func (kr *keyring) due(now time.Time) (material []byte, keys sessionKeys, ok bool) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if kr.pending == nil || now.Before(kr.pendingResendAt) {
+		return nil, sessionKeys{}, false
+	}
+	if kr.pendingAttempts >= maxSendAttempts {
+		kr.pending = nil
+		kr.pendingKeys = sessionKeys{}
+		return nil, sessionKeys{}, false
+	}
+
+	kr.pendingAttempts++
+	kr.pendingResendAt = now.Add(rekeyRetransmitInterval)
+	return kr.pending, kr.current, true
+}
+
+// received handles keying material sent by the peer, returning whether it must be echoed back
+// keys are the ones the datagram carrying it was authenticated with, notably the previous ones if the peer didn't
+// receive our echo and retransmitted it
+func (kr *keyring) received(material []byte, keys sessionKeys, now time.Time) (echo bool) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	switch {
+	case kr.pending != nil && bytes.Equal(material, kr.pending):
+		// The peer echoed our material
+		kr.rotate(kr.pending, kr.pendingKeys, true, now)
+		return false
+	case kr.last != nil && bytes.Equal(material, kr.last):
+		// A retransmission of the last rekeying, which we must echo again if the peer initiated it and is still
+		// using the previous keys
+		return !kr.lastInitiated && bytes.Equal(keys.macKey, kr.previous.macKey)
+	case kr.pending != nil && bytes.Compare(kr.pending, material) > 0:
+		// Both sides initiated a rekeying at once: the greatest material wins, here ours
+		return false
+	}
+
+	// The peer initiated a rekeying: we switch as soon as we have echoed it
+	macKey, sessionKey, err := keysFromKeyingMaterial(material)
+	if err != nil {
+		return false
+	}
+	kr.rotate(material, sessionKeys{macKey: macKey, sessionKey: sessionKey}, false, now)
+	return true
+}
+
+// promote switches to the pending keys, once the peer used them
+func (kr *keyring) promote(now time.Time) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if kr.pending != nil {
+		kr.rotate(kr.pending, kr.pendingKeys, true, now)
+	}
+}
+
+// rotate switches to the keys derived from the given material, keeping the current ones for the grace period
+// The caller must hold the lock
+func (kr *keyring) rotate(material []byte, keys sessionKeys, initiated bool, now time.Time) {
+	kr.previous = kr.current
+	kr.previousExpires = now.Add(rekeyGracePeriod)
+	kr.current = keys
+
+	kr.pending = nil
+	kr.pendingKeys = sessionKeys{}
+	kr.last = material
+	kr.lastInitiated = initiated
+}
+
+// Rekey starts negotiating new keys with the peer, returning once our keying material is sent
+// The current keys are used until the peer replies, and remain valid for a short while afterwards.
+// It does nothing if a rekeying is already in progress.
+func (conn *Conn) Rekey() error {
+	select {
+	case <-conn.closed:
+		return conn.err
+	default:
+	}
+
+	material, keys, ok, err := conn.keys.start(time.Now())
+	if err != nil || !ok {
+		return err
+	}
+	return conn.writeRekey(material, keys)
+}
+
+// writeRekey sends keying material to the peer, in an empty data message using the given keys
+func (conn *Conn) writeRekey(material []byte, keys sessionKeys) error {
+	b, err := new(dataMessage).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	d := &datagram{
+		Flag:           composeFlag(payloadData, true, false),
+		KeyingMaterial: material,
+		Payload:        b,
+	}
+	return conn.writeDatagram(d, keys)
+}
+
+// startRekeying rekeys the session periodically until it is closed, unless the interval is zero
+func (conn *Conn) startRekeying(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				conn.Rekey()
+			case <-conn.closed:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package ssu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// newTestKeyrings returns the keyrings of both ends of a session
+func newTestKeyrings(t *testing.T) (*keyring, *keyring) {
+	sessionKey, macKey := make([]byte, sessionKeySize), make([]byte, macKeySize)
+	rand.Read(sessionKey)
+	rand.Read(macKey)
+	return newKeyring(sessionKey, macKey), newKeyring(sessionKey, macKey)
+}
+
+// sealWith marshals a datagram with the given keys
+func sealWith(t *testing.T, keys sessionKeys) []byte {
+	d := &datagram{
+		Flag:    composeFlag(payloadData, false, false),
+		Payload: []byte("payload"),
+	}
+	b, err := d.MarshalBinary(keys.macKey, keys.sessionKey)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	return b
+}
+
+func TestKeyring(t *testing.T) {
+	alice, bob := newTestKeyrings(t)
+	now := time.Now()
+	old := alice.keys()
+
+	// Alice initiates
+	material, keys, ok, err := alice.start(now)
+	if err != nil || !ok {
+		t.Fatalf("failed to start rekeying: %v", err)
+	}
+	if !bytes.Equal(keys.macKey, old.macKey) {
+		t.Error("keying material not sent with the current keys")
+	}
+	if _, _, ok, _ := alice.start(now); ok {
+		t.Error("started a second rekeying while one is in progress")
+	}
+
+	// Bob switches as it echoes it
+	if !bob.received(material, keys, now) {
+		t.Fatal("Bob didn't echo the keying material")
+	}
+	if bytes.Equal(bob.keys().macKey, old.macKey) {
+		t.Fatal("Bob didn't switch keys")
+	}
+
+	// Alice accepts datagrams with the new keys before getting the echo, and switches on getting it
+	if _, pending, ok := alice.lookup(sealWith(t, bob.keys()), now); !ok || !pending {
+		t.Error("datagram with the proposed keys not recognised")
+	}
+	if alice.received(material, old, now) {
+		t.Error("Alice echoed an echo")
+	}
+	if !bytes.Equal(alice.keys().macKey, bob.keys().macKey) || !bytes.Equal(alice.keys().sessionKey, bob.keys().sessionKey) {
+		t.Fatal("keys differ after rekeying")
+	}
+	expected, _, _ := keysFromKeyingMaterial(material)
+	if !bytes.Equal(alice.keys().macKey, expected) {
+		t.Error("MAC key not derived from the keying material")
+	}
+
+	// A retransmission with the previous keys is echoed again by Bob only
+	if !bob.received(material, old, now) {
+		t.Error("Bob didn't echo a retransmission")
+	}
+	if alice.received(material, old, now) {
+		t.Error("Alice echoed a duplicate echo")
+	}
+	if bob.received(material, bob.keys(), now) {
+		t.Error("Bob echoed material sent with the new keys")
+	}
+
+	// The previous keys remain valid for the grace period only
+	b := sealWith(t, old)
+	if keys, _, ok := bob.lookup(b, now.Add(rekeyGracePeriod/2)); !ok || !bytes.Equal(keys.macKey, old.macKey) {
+		t.Error("previous keys rejected during the grace period")
+	}
+	if _, _, ok := bob.lookup(b, now.Add(rekeyGracePeriod+time.Second)); ok {
+		t.Error("previous keys accepted after the grace period")
+	}
+}
+
+func TestKeyring_Simultaneous(t *testing.T) {
+	alice, bob := newTestKeyrings(t)
+	now := time.Now()
+	old := alice.keys()
+
+	// Both initiate at once, and receive each other's material
+	aliceMaterial, _, _, _ := alice.start(now)
+	bobMaterial, _, _, _ := bob.start(now)
+	aliceEchoes := alice.received(bobMaterial, old, now)
+	bobEchoes := bob.received(aliceMaterial, old, now)
+	if aliceEchoes == bobEchoes {
+		t.Fatalf("expected a single side to echo, got %t and %t", aliceEchoes, bobEchoes)
+	}
+
+	// The echo settles it on the greatest material
+	winner := aliceMaterial
+	if aliceEchoes {
+		winner = bobMaterial
+		bob.received(bobMaterial, old, now)
+	} else {
+		alice.received(aliceMaterial, old, now)
+	}
+	expected, _, _ := keysFromKeyingMaterial(winner)
+	if !bytes.Equal(alice.keys().macKey, expected) || !bytes.Equal(bob.keys().macKey, expected) {
+		t.Error("both sides didn't settle on the same keys")
+	}
+}
+
+func TestKeyring_Abandon(t *testing.T) {
+	kr, _ := newTestKeyrings(t)
+	now := time.Now()
+	old := kr.keys()
+	material, _, _, _ := kr.start(now)
+
+	// The material is resent until we give up
+	for i := 1; i < maxSendAttempts; i++ {
+		now = now.Add(rekeyRetransmitInterval)
+		resent, _, ok := kr.due(now)
+		if !ok || !bytes.Equal(resent, material) {
+			t.Fatalf("keying material not resent on attempt %d", i+1)
+		}
+	}
+	if _, _, ok := kr.due(now.Add(rekeyRetransmitInterval)); ok {
+		t.Error("keying material resent after too many attempts")
+	}
+
+	// Keeping the current keys
+	if !bytes.Equal(kr.keys().macKey, old.macKey) {
+		t.Error("keys changed by an abandoned rekeying")
+	}
+	if _, _, ok, _ := kr.start(now); !ok {
+		t.Error("failed to rekey again after abandoning")
+	}
+}
+
+func TestConn_Rekey(t *testing.T) {
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), newTestDialer(t))
+	old := aliceConn.keys.keys()
+
+	if err := aliceConn.Rekey(); err != nil {
+		t.Fatalf("error in Rekey: %v", err)
+	}
+
+	// Both sides switch to the same new keys
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a, b := aliceConn.keys.keys(), bobConn.keys.keys()
+		if !bytes.Equal(a.macKey, old.macKey) && bytes.Equal(a.macKey, b.macKey) && bytes.Equal(a.sessionKey, b.sessionKey) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("keys not renegotiated in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// And the session goes on with them
+	for _, pair := range []struct{ from, to *Conn }{{aliceConn, bobConn}, {bobConn, aliceConn}} {
+		if _, err := pair.from.Write([]byte("hello")); err != nil {
+			t.Fatalf("error in Write: %v", err)
+		}
+		if got := readMessage(t, pair.to); string(got) != "hello" {
+			t.Errorf("got %q", got)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package ssu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// maxChallengeSize is the largest challenge representable, as its size is a single byte
+const maxChallengeSize = 1<<8 - 1
+
+// errTooSmall is returned when unmarshalling a truncated relay or peer test message
+var errTooSmall = errors.New("message is invalid: too small")
+
+// appendAddr appends the size of an IP address, the address itself and the port
+// An empty IP address is written with a zero size, for the addresses which are optional
+func appendAddr(b []byte, addr *net.UDPAddr) ([]byte, error) {
+	if len(addr.IP) != 0 && len(addr.IP) != 4 && len(addr.IP) != 16 {
+		return nil, errors.New("invalid IP address length")
+	} else if addr.Port < 0 || addr.Port > 1<<16-1 {
+		return nil, errors.New("port overflows uint16: cannot represent it in two bytes")
+	}
+
+	b = append(b, byte(len(addr.IP)))
+	b = append(b, addr.IP...)
+	b = binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+	return b, nil
+}
+
+// readAddr reads an address written by appendAddr, returning the bytes following it
+// Does not retain b
+func readAddr(b []byte, addr *net.UDPAddr) ([]byte, error) {
+	if len(b) < 1 {
+		return nil, errTooSmall
+	}
+	size := int(b[0])
+	if size != 0 && size != 4 && size != 16 {
+		return nil, fmt.Errorf("IP size indicator is neither 0, 4 nor 16 but %d", size)
+	} else if len(b) < 1+size+2 {
+		return nil, errTooSmall
+	}
+
+	addr.IP = nil
+	if size != 0 {
+		addr.IP = make(net.IP, size)
+		copy(addr.IP, b[1:1+size])
+	}
+	addr.Port = int(binary.BigEndian.Uint16(b[1+size:]))
+
+	return b[1+size+2:], nil
+}
+
+// appendChallenge appends the size of a challenge and the challenge itself
+func appendChallenge(b []byte, challenge []byte) ([]byte, error) {
+	if len(challenge) > maxChallengeSize {
+		return nil, errors.New("challenge too large")
+	}
+	b = append(b, byte(len(challenge)))
+	return append(b, challenge...), nil
+}
+
+// readChallenge reads a challenge written by appendChallenge, returning the bytes following it
+// Does not retain b
+func readChallenge(b []byte) (challenge []byte, rest []byte, err error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, errTooSmall
+	}
+	size := int(b[0])
+	if size != 0 {
+		challenge = make([]byte, size)
+		copy(challenge, b[1:1+size])
+	}
+	return challenge, b[1+size:], nil
+}
+
+/*
+A relayRequest is sent by Alice to Bob, to request an introduction to Charlie
+
++----+----+----+----+----+----+----+----+
+|      relay tag    |size| Alice IP addr
++----+----+----+----+----+----+----+----+
+     | Port (A)|size| challenge bytes   |
++----+----+----+----+                   +
+|      to be delivered to Charlie       |
++----+----+----+----+----+----+----+----+
+| Alice's intro key                     |
++                                       +
+|                                       |
++                                       +
+|                                       |
++                                       +
+|                                       |
++----+----+----+----+----+----+----+----+
+|       nonce       |                   |
++----+----+----+----+                   +
+| arbitrary amount of uninterpreted data|
+~                .  .  .                ~
+*/
+type relayRequest struct {
+	// Relay tag given to Alice by Bob in its session created message, identifying Charlie
+	RelayTag [4]byte
+
+	// Alice's address, only included if different from the source of the datagram
+	// In the current implementation it is always empty, with a zero port
+	AliceAddr net.UDPAddr
+
+	// Bytes to be relayed to Charlie
+	Challenge []byte
+
+	// Alice's intro key, so that Bob can reply with Charlie's address
+	IntroKey [introKeySize]byte
+
+	// Nonce of the request, echoed in the response
+	Nonce uint32
+}
+
+func (rr *relayRequest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 4+1+16+2+1+len(rr.Challenge)+introKeySize+4)
+
+	// Relay tag
+	b = append(b, rr.RelayTag[:]...)
+
+	// Alice's address
+	b, err := appendAddr(b, &rr.AliceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Challenge
+	b, err = appendChallenge(b, rr.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	// Intro key & nonce
+	b = append(b, rr.IntroKey[:]...)
+	b = binary.BigEndian.AppendUint32(b, rr.Nonce)
+
+	return b, nil
+}
+
+// Does not retain b
+func (rr *relayRequest) UnmarshalBinary(b []byte) error {
+	// Relay tag
+	if len(b) < 4 {
+		return errTooSmall
+	}
+	copy(rr.RelayTag[:], b[:4])
+
+	// Alice's address
+	b, err := readAddr(b[4:], &rr.AliceAddr)
+	if err != nil {
+		return err
+	}
+
+	// Challenge
+	rr.Challenge, b, err = readChallenge(b)
+	if err != nil {
+		return err
+	}
+
+	// Intro key & nonce
+	if len(b) < introKeySize+4 {
+		return errTooSmall
+	}
+	copy(rr.IntroKey[:], b[:introKeySize])
+	rr.Nonce = binary.BigEndian.Uint32(b[introKeySize:])
+
+	// Any following data is uninterpreted
+	return nil
+}
+
+/*
+A relayResponse is sent by Bob to Alice in response to a relay request, giving Charlie's address
+
++----+----+----+----+----+----+----+----+
+|size|    Charlie IP     | Port (C)|size|
++----+----+----+----+----+----+----+----+
+|    Alice IP       | Port (A)|  nonce
++----+----+----+----+----+----+----+----+
+          |   arbitrary amount of       |
++----+----+                             +
+|          uninterpreted data           |
+~                .  .  .                ~
+*/
+type relayResponse struct {
+	// Charlie's address, which must be IPv4
+	CharlieAddr net.UDPAddr
+
+	// Alice's address, as seen by Bob when receiving the request
+	AliceAddr net.UDPAddr
+
+	// Nonce of the request
+	Nonce uint32
+}
+
+func (rr *relayResponse) MarshalBinary() ([]byte, error) {
+	if len(rr.CharlieAddr.IP) != 4 {
+		return nil, errors.New("IP address of Charlie must be IPv4")
+	} else if len(rr.AliceAddr.IP) != 4 && len(rr.AliceAddr.IP) != 16 {
+		return nil, errors.New("invalid IP address length")
+	}
+
+	b := make([]byte, 0, 1+4+2+1+16+2+4)
+
+	// Charlie's then Alice's address
+	b, err := appendAddr(b, &rr.CharlieAddr)
+	if err != nil {
+		return nil, err
+	}
+	b, err = appendAddr(b, &rr.AliceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nonce
+	b = binary.BigEndian.AppendUint32(b, rr.Nonce)
+
+	return b, nil
+}
+
+// Does not retain b
+func (rr *relayResponse) UnmarshalBinary(b []byte) error {
+	// Charlie's then Alice's address
+	b, err := readAddr(b, &rr.CharlieAddr)
+	if err != nil {
+		return err
+	}
+	b, err = readAddr(b, &rr.AliceAddr)
+	if err != nil {
+		return err
+	}
+
+	// Nonce
+	if len(b) < 4 {
+		return errTooSmall
+	}
+	rr.Nonce = binary.BigEndian.Uint32(b)
+
+	// Any following data is uninterpreted
+	return nil
+}
+
+/*
+A relayIntro is sent by Bob to Charlie, introducing Alice
+
++----+----+----+----+----+----+----+----+
+|size|     Alice IP      | Port (A)|size|
++----+----+----+----+----+----+----+----+
+|      that many bytes of challenge     |
++                                       +
+|        data relayed from Alice        |
++----+----+----+----+----+----+----+----+
+| arbitrary amount of uninterpreted data|
+~                .  .  .                ~
+*/
+type relayIntro struct {
+	// Alice's address, which is IPv4 in the current implementation
+	AliceAddr net.UDPAddr
+
+	// Bytes relayed from Alice
+	Challenge []byte
+}
+
+func (ri *relayIntro) MarshalBinary() ([]byte, error) {
+	if len(ri.AliceAddr.IP) != 4 && len(ri.AliceAddr.IP) != 16 {
+		return nil, errors.New("invalid IP address length")
+	}
+
+	b := make([]byte, 0, 1+16+2+1+len(ri.Challenge))
+
+	// Alice's address
+	b, err := appendAddr(b, &ri.AliceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Challenge
+	return appendChallenge(b, ri.Challenge)
+}
+
+// Does not retain b
+func (ri *relayIntro) UnmarshalBinary(b []byte) error {
+	// Alice's address
+	b, err := readAddr(b, &ri.AliceAddr)
+	if err != nil {
+		return err
+	}
+
+	// Challenge
+	ri.Challenge, _, err = readChallenge(b)
+	if err != nil {
+		return err
+	}
+
+	// Any following data is uninterpreted
+	return nil
+}
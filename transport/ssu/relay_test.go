@@ -0,0 +1,153 @@
+package ssu
+
+import (
+	"encoding"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// relayMessage is implemented by the relay messages
+type relayMessage interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+func TestRelay_MarshallingCoherence(t *testing.T) {
+	introKey := [introKeySize]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+
+	var tests = []struct {
+		desc     string
+		msg      relayMessage
+		empty    relayMessage
+		expected int // expected length
+	}{
+		{
+			"request without address",
+			&relayRequest{RelayTag: [4]byte{1, 2, 3, 4}, IntroKey: introKey, Nonce: 42},
+			new(relayRequest),
+			4 + 1 + 2 + 1 + introKeySize + 4,
+		},
+		{
+			"request with IPv4 address and challenge",
+			&relayRequest{
+				RelayTag:  [4]byte{1, 2, 3, 4},
+				AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234},
+				Challenge: []byte("challenge"),
+				IntroKey:  introKey,
+				Nonce:     42,
+			},
+			new(relayRequest),
+			4 + 1 + 4 + 2 + 1 + 9 + introKeySize + 4,
+		},
+		{
+			"response with IPv4 Alice",
+			&relayResponse{
+				CharlieAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 3}, Port: 5678},
+				AliceAddr:   net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234},
+				Nonce:       42,
+			},
+			new(relayResponse),
+			1 + 4 + 2 + 1 + 4 + 2 + 4,
+		},
+		{
+			"response with IPv6 Alice",
+			&relayResponse{
+				CharlieAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 3}, Port: 5678},
+				AliceAddr:   net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+				Nonce:       42,
+			},
+			new(relayResponse),
+			1 + 4 + 2 + 1 + 16 + 2 + 4,
+		},
+		{
+			"intro",
+			&relayIntro{AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}},
+			new(relayIntro),
+			1 + 4 + 2 + 1,
+		},
+		{
+			"intro with challenge",
+			&relayIntro{AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1234}, Challenge: []byte{0xCA, 0xFE}},
+			new(relayIntro),
+			1 + 4 + 2 + 1 + 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			b, err := test.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error in MarshalBinary: %v", err)
+			}
+			if len(b) != test.expected {
+				t.Errorf("expected %d bytes, got %d", test.expected, len(b))
+			}
+
+			// Uninterpreted data may follow
+			b = append(b, 0xDE, 0xAD)
+			if err := test.empty.UnmarshalBinary(b); err != nil {
+				t.Fatalf("error in UnmarshalBinary: %v", err)
+			}
+			if !reflect.DeepEqual(test.msg, test.empty) {
+				t.Errorf("unmarshalled message differs:\n%+v\n%+v", test.msg, test.empty)
+			}
+
+			// Truncation is detected
+			for n := 0; n < test.expected; n++ {
+				if err := test.empty.UnmarshalBinary(b[:n]); err == nil {
+					t.Errorf("no error unmarshalling the first %d bytes", n)
+				}
+			}
+		})
+	}
+}
+
+func TestRelay_MarshalInvalid(t *testing.T) {
+	var tests = []struct {
+		desc string
+		msg  relayMessage
+	}{
+		{"request with invalid IP", &relayRequest{AliceAddr: net.UDPAddr{IP: net.IP{1, 2, 3}}}},
+		{"request with challenge too large", &relayRequest{Challenge: make([]byte, maxChallengeSize+1)}},
+		{"response with IPv6 Charlie", &relayResponse{
+			CharlieAddr: net.UDPAddr{IP: net.ParseIP("2001:db8::1")},
+			AliceAddr:   net.UDPAddr{IP: net.IP{192, 0, 2, 1}},
+		}},
+		{"response without Alice", &relayResponse{CharlieAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 3}}}},
+		{"intro without Alice", &relayIntro{}},
+		{"intro with port overflow", &relayIntro{AliceAddr: net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 1 << 16}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := test.msg.MarshalBinary(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestPayloadTypes checks the payload types against the spec
+func TestPayloadTypes(t *testing.T) {
+	var types = []struct {
+		name     string
+		got      int
+		expected int
+	}{
+		{"SessionRequest", payloadSessionRequest, 0},
+		{"SessionCreated", payloadSessionCreated, 1},
+		{"SessionConfirmed", payloadSessionConfirmed, 2},
+		{"RelayRequest", payloadRelayRequest, 3},
+		{"RelayResponse", payloadRelayResponse, 4},
+		{"RelayIntro", payloadRelayIntro, 5},
+		{"Data", payloadData, 6},
+		{"PeerTest", payloadPeerTest, 7},
+		{"SessionDestroyed", payloadSessionDestroyed, 8},
+	}
+	for _, pt := range types {
+		if pt.got != pt.expected {
+			t.Errorf("%s has type %d instead of %d", pt.name, pt.got, pt.expected)
+		}
+	}
+}
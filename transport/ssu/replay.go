@@ -0,0 +1,109 @@
+package ssu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultMaxClockSkew is the clock skew allowed if the Dialer doesn't specify one
+const defaultMaxClockSkew = time.Minute
+
+// ErrReplayedDatagram is reported when dropping a datagram already received over the session
+var ErrReplayedDatagram = errors.New("datagram replayed")
+
+// A ClockSkewError is reported when dropping a datagram whose time is outside the allowed clock skew
+type ClockSkewError struct {
+	// Difference between the time of the datagram and ours, positive if it is in the future
+	Skew time.Duration
+}
+
+func (e *ClockSkewError) Error() string {
+	return fmt.Sprintf("datagram time off by %v, beyond the allowed clock skew", e.Skew)
+}
+
+// clockTolerance returns how far from ours the time of a datagram may be, as it is truncated to the second
+func clockTolerance(maxSkew time.Duration) time.Duration {
+	return maxSkew + time.Second
+}
+
+// checkClockSkew checks that the time of a datagram is within the allowed skew of ours
+func checkClockSkew(d *datagram, now time.Time, maxSkew time.Duration) error {
+	skew := time.Unix(int64(d.Time), 0).Sub(now)
+	if tolerance := clockTolerance(maxSkew); skew > tolerance || skew < -tolerance {
+		return &ClockSkewError{Skew: skew.Truncate(time.Second)}
+	}
+	return nil
+}
+
+// replayEntry is a datagram remembered by a replayCache
+type replayEntry struct {
+	mac     [16]byte
+	expires time.Time
+}
+
+// replayCache remembers the MACs of the datagrams received over a session, to drop the replayed ones
+// Datagrams are remembered as long as their time may be valid, that is twice the clock tolerance, as older ones are
+// dropped for their time. Its size is thus bounded by the number of datagrams the peer sends within that window, about
+// 40 bytes each, as only the peer can authenticate them.
+// It is only used by the reading loop, and thus isn't safe for concurrent use.
+type replayCache struct {
+	window time.Duration
+	seen   map[[16]byte]struct{}
+	order  []replayEntry
+}
+
+func newReplayCache(maxSkew time.Duration) *replayCache {
+	return &replayCache{
+		window: 2 * clockTolerance(maxSkew),
+		seen:   make(map[[16]byte]struct{}),
+	}
+}
+
+// check records the MAC of an authenticated datagram, failing if it was already received
+func (rc *replayCache) check(b []byte, now time.Time) error {
+	// Forget the expired datagrams
+	for len(rc.order) != 0 && now.After(rc.order[0].expires) {
+		delete(rc.seen, rc.order[0].mac)
+		rc.order = rc.order[1:]
+	}
+
+	var mac [16]byte
+	copy(mac[:], b[macPos:ivPos])
+	if _, ok := rc.seen[mac]; ok {
+		return ErrReplayedDatagram
+	}
+	rc.seen[mac] = struct{}{}
+	rc.order = append(rc.order, replayEntry{mac: mac, expires: now.Add(rc.window)})
+	return nil
+}
+
+// maxClockSkew returns the clock skew allowed on inbound datagrams
+func (d *Dialer) maxClockSkew() time.Duration {
+	if d.MaxClockSkew <= 0 {
+		return defaultMaxClockSkew
+	}
+	return d.MaxClockSkew
+}
+
+// dropped reports an inbound datagram dropped, if the Dialer asks for it
+func (d *Dialer) dropped(addr net.Addr, err error) {
+	if d.OnDrop != nil {
+		d.OnDrop(addr, err)
+	}
+}
+
+// checkReplay checks that an authenticated datagram of the session is neither outdated nor replayed, reporting it
+// otherwise
+func (conn *Conn) checkReplay(b []byte, d *datagram, now time.Time) bool {
+	err := checkClockSkew(d, now, conn.opts.maxClockSkew())
+	if err == nil {
+		err = conn.replays.check(b, now)
+	}
+	if err != nil {
+		conn.opts.dropped(conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package ssu
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Now()
+
+	var tests = []struct {
+		desc  string
+		time  time.Time
+		valid bool
+	}{
+		{"now", now, true},
+		{"within the window in the past", now.Add(-50 * time.Second), true},
+		{"within the window in the future", now.Add(50 * time.Second), true},
+		{"too old", now.Add(-2 * time.Minute), false},
+		{"too far in the future", now.Add(2 * time.Minute), false},
+		{"zero", time.Unix(0, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := &datagram{Time: uint32(test.time.Unix())}
+			err := checkClockSkew(d, now, time.Minute)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			var skewErr *ClockSkewError
+			if !test.valid && !errors.As(err, &skewErr) {
+				t.Errorf("expected a clock skew error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	rc := newReplayCache(time.Minute)
+	now := time.Now()
+	b := make([]byte, nominalHeaderLen)
+
+	// Duplicates are detected
+	if err := rc.check(b, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rc.check(b, now.Add(time.Second)); err != ErrReplayedDatagram {
+		t.Errorf("expected a replay, got %v", err)
+	}
+
+	// However many datagrams follow within the window
+	for i := 0; i < 10000; i++ {
+		b[0], b[1] = byte(i), byte(i>>8)
+		rc.check(b, now.Add(time.Minute))
+	}
+	b[0], b[1] = 0, 0
+	if err := rc.check(b, now.Add(time.Minute)); err != ErrReplayedDatagram {
+		t.Errorf("expected a replay, got %v", err)
+	}
+
+	// Until they are too old to pass the time check anyway, and are forgotten
+	if err := rc.check(b, now.Add(4*time.Minute)); err != nil {
+		t.Errorf("unexpected error once expired: %v", err)
+	}
+	if len(rc.seen) != 1 || len(rc.order) != 1 {
+		t.Errorf("%d datagrams remembered, expected only the last one", len(rc.seen))
+	}
+}
+
+func TestReplayCache_Edge(t *testing.T) {
+	const maxSkew = time.Minute
+	rc := newReplayCache(maxSkew)
+	now := time.Unix(time.Now().Unix(), 0)
+	b := make([]byte, nominalHeaderLen)
+
+	// A datagram as far in the future as allowed is received, then replayed once it is as far in the past as allowed
+	d := &datagram{Time: uint32(now.Add(clockTolerance(maxSkew)).Unix())}
+	for i, at := range []time.Time{now, now.Add(2 * clockTolerance(maxSkew))} {
+		if err := checkClockSkew(d, at, maxSkew); err != nil {
+			t.Fatalf("reception %d: unexpected error: %v", i, err)
+		}
+		err := rc.check(b, at)
+		if i == 0 && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i == 1 && err != ErrReplayedDatagram {
+			t.Errorf("expected a replay, got %v", err)
+		}
+	}
+}
+
+func TestConn_Replay(t *testing.T) {
+	dropped := make(chan error, 2)
+	alice := newTestDialer(t)
+	alice.OnDrop = func(addr net.Addr, err error) { dropped <- err }
+	aliceConn, bobConn := newTestConnPair(t, newTestDialer(t), alice)
+	keys := aliceConn.keys.keys()
+
+	// A datagram received twice is dropped the second time
+	b, err := new(dataMessage).MarshalBinary()
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	d := &datagram{
+		Flag:    composeFlag(payloadData, false, false),
+		Time:    uint32(time.Now().Unix()),
+		Payload: b,
+	}
+	db, err := d.MarshalBinary(keys.macKey, keys.sessionKey)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := bobConn.underlying.Write(db); err != nil {
+			t.Fatalf("error in Write: %v", err)
+		}
+	}
+	select {
+	case err := <-dropped:
+		if err != ErrReplayedDatagram {
+			t.Errorf("expected a replay, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay not reported")
+	}
+
+	// An outdated one is dropped too
+	d.Time = uint32(time.Now().Add(-time.Hour).Unix())
+	db, err = d.MarshalBinary(keys.macKey, keys.sessionKey)
+	if err != nil {
+		t.Fatalf("error in MarshalBinary: %v", err)
+	}
+	if _, err := bobConn.underlying.Write(db); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	select {
+	case err := <-dropped:
+		var skewErr *ClockSkewError
+		if !errors.As(err, &skewErr) || skewErr.Skew > -time.Hour+time.Minute {
+			t.Errorf("expected a clock skew of about an hour, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("clock skew not reported")
+	}
+
+	// The session is unaffected
+	if _, err := bobConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("error in Write: %v", err)
+	}
+	if got := readMessage(t, aliceConn); string(got) != "hello" {
+		t.Errorf("got %q", got)
+	}
+}
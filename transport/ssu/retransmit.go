@@ -0,0 +1,284 @@
+package ssu
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Bounds of the retransmission timeout
+	minRTO = 100 * time.Millisecond
+	maxRTO = 3 * time.Second
+
+	// initialRTO is the retransmission timeout used before the first RTT sample, as in RFC 6298
+	initialRTO = time.Second
+
+	// maxSendAttempts is the number of times the fragments of a message are sent before it fails
+	maxSendAttempts = 8
+
+	// retransmitInterval is the period at which the unacknowledged messages are checked for retransmission
+	retransmitInterval = minRTO / 4
+)
+
+// ErrDeliveryFailed is returned by Write when a message wasn't acknowledged by the peer after maxSendAttempts transmissions
+var ErrDeliveryFailed = errors.New("message not acknowledged by the peer")
+
+// rttEstimator estimates the round-trip time of a session, and derives the retransmission timeout from it as in RFC 6298
+type rttEstimator struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	sampled bool
+}
+
+// sample updates the estimation with a measured round-trip time
+func (e *rttEstimator) sample(rtt time.Duration) {
+	if !e.sampled {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.sampled = true
+		return
+	}
+
+	diff := e.srtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	e.rttvar = (3*e.rttvar + diff) / 4
+	e.srtt = (7*e.srtt + rtt) / 8
+}
+
+// timeout returns the retransmission timeout
+func (e *rttEstimator) timeout() time.Duration {
+	if !e.sampled {
+		return initialRTO
+	}
+
+	rto := e.srtt + 4*e.rttvar
+	switch {
+	case rto < minRTO:
+		return minRTO
+	case rto > maxRTO:
+		return maxRTO
+	}
+	return rto
+}
+
+// outboundMessage is a message sent but not yet fully acknowledged
+type outboundMessage struct {
+	fragments []dataFragment
+
+	// Fragments sent so far, as the congestion window may delay them, and the ones acknowledged
+	sent  fragmentSet
+	acked fragmentSet
+
+	// Number of transmissions of each fragment so far, and time of its next retransmission
+	// They start once the fragment is first sent, not while it waits for room in the window.
+	attempts     []int
+	retransmitAt []time.Time
+
+	// Time of the first transmission of the last fragment sent, and whether any fragment was retransmitted
+	lastSent      time.Time
+	retransmitted bool
+
+	// Receives the outcome of the delivery
+	done chan error
+}
+
+// inFlight returns the number of bytes of the fragments sent but not acknowledged
+func (m *outboundMessage) inFlight() int {
+	n := 0
+	for i, f := range m.fragments {
+		if m.sent.has(byte(i)) && !m.acked.has(byte(i)) {
+			n += fragmentCost(f)
+		}
+	}
+	return n
+}
+
+// retransmitter tracks the outbound messages of a session until they are acknowledged, deciding when to resend them
+type retransmitter struct {
+	mu       sync.Mutex
+	messages map[uint32]*outboundMessage
+	rtt      rttEstimator
+
+	// cwnd is informed of the fragments acknowledged and lost
+	cwnd *congestionWindow
+
+	// maxAttempts is the number of transmissions after which a message fails
+	maxAttempts int
+}
+
+func newRetransmitter(cwnd *congestionWindow) *retransmitter {
+	return &retransmitter{
+		messages:    make(map[uint32]*outboundMessage),
+		cwnd:        cwnd,
+		maxAttempts: maxSendAttempts,
+	}
+}
+
+// track registers a message whose fragments are about to be sent for the first time
+// The returned channel receives nil once the message is acknowledged, or an error if it fails
+func (r *retransmitter) track(id uint32, fragments []dataFragment) <-chan error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := &outboundMessage{
+		fragments:    fragments,
+		attempts:     make([]int, len(fragments)),
+		retransmitAt: make([]time.Time, len(fragments)),
+		done:         make(chan error, 1),
+	}
+	r.messages[id] = m
+	return m.done
+}
+
+// markSent records the first transmission of a fragment, starting its retransmission timer
+// It returns false if its message isn't tracked anymore.
+func (r *retransmitter) markSent(f dataFragment, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[f.MessageID]
+	if !ok {
+		return false
+	}
+	m.sent.add(f.Num)
+	m.attempts[f.Num] = 1
+	m.retransmitAt[f.Num] = now.Add(r.rtt.timeout())
+	m.lastSent = now
+	return true
+}
+
+// forget stops tracking a message, without reporting anything
+func (r *retransmitter) forget(id uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.messages[id]; ok {
+		delete(r.messages, id)
+		r.cwnd.released(m.inFlight())
+	}
+}
+
+// ack handles the explicit ACK of a whole message
+func (r *retransmitter) ack(id uint32, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Duplicate ACKs are ignored
+	m, ok := r.messages[id]
+	if !ok {
+		return
+	}
+
+	r.cwnd.acked(m.inFlight())
+	r.acknowledged(id, m, now)
+}
+
+// ackPartial handles the ACK bitfield of a message
+func (r *retransmitter) ackPartial(id uint32, received fragmentSet, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[id]
+	if !ok {
+		return
+	}
+
+	// Mark the fragments received, only the new ones counting as acknowledged bytes
+	n := 0
+	for i, f := range m.fragments {
+		if received.has(byte(i)) && m.sent.has(byte(i)) && !m.acked.has(byte(i)) {
+			m.acked.add(byte(i))
+			n += fragmentCost(f)
+		}
+	}
+	r.cwnd.acked(n)
+
+	if m.acked.len() == len(m.fragments) {
+		r.acknowledged(id, m, now)
+	}
+}
+
+// acknowledged completes a fully acknowledged message
+// Following Karn's algorithm, only the messages which weren't retransmitted give an RTT sample
+func (r *retransmitter) acknowledged(id uint32, m *outboundMessage, now time.Time) {
+	if !m.retransmitted {
+		r.rtt.sample(now.Sub(m.lastSent))
+	}
+	delete(r.messages, id)
+	m.done <- nil
+}
+
+// due returns the fragments in flight whose retransmission timeout expired
+// The messages with such a fragment already sent maxAttempts times fail with ErrDeliveryFailed instead
+func (r *retransmitter) due(now time.Time) []dataFragment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var fragments []dataFragment
+	for id, m := range r.messages {
+		// Find the expired fragments, the ones waiting for room in the window having no timer yet
+		var expired []int
+		failed := false
+		for i := range m.fragments {
+			if m.sent.has(byte(i)) && !m.acked.has(byte(i)) && !now.Before(m.retransmitAt[i]) {
+				expired = append(expired, i)
+				failed = failed || m.attempts[i] >= r.maxAttempts
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		if failed {
+			r.abandon(id, m, ErrDeliveryFailed)
+			continue
+		}
+
+		// A timeout of fragments in flight is a sign of congestion
+		r.cwnd.lost(now, r.rtt.timeout())
+		m.retransmitted = true
+
+		// Resend them, backing off exponentially
+		for _, i := range expired {
+			backoff := r.rtt.timeout() << uint(m.attempts[i])
+			if backoff > maxRTO || backoff <= 0 {
+				backoff = maxRTO
+			}
+			m.attempts[i]++
+			m.retransmitAt[i] = now.Add(backoff)
+			fragments = append(fragments, m.fragments[i])
+		}
+	}
+
+	return fragments
+}
+
+// fail fails every pending message with the given error
+func (r *retransmitter) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, m := range r.messages {
+		r.abandon(id, m, err)
+	}
+}
+
+// abandon stops tracking a message which failed, and reports it
+func (r *retransmitter) abandon(id uint32, m *outboundMessage, err error) {
+	delete(r.messages, id)
+	r.cwnd.released(m.inFlight())
+	m.done <- err
+}
+
+// state returns a snapshot of the congestion control state
+func (r *retransmitter) state() CongestionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.cwnd.state()
+	state.RTT = r.rtt.srtt
+	state.RTO = r.rtt.timeout()
+	return state
+}
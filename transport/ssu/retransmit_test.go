@@ -0,0 +1,161 @@
+package ssu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	var e rttEstimator
+	if e.timeout() != initialRTO {
+		t.Errorf("expected the initial RTO %v before any sample, got %v", initialRTO, e.timeout())
+	}
+
+	// A steady RTT converges to it, bounded by minRTO
+	for i := 0; i < 50; i++ {
+		e.sample(200 * time.Millisecond)
+	}
+	if e.srtt != 200*time.Millisecond {
+		t.Errorf("expected a smoothed RTT of 200ms, got %v", e.srtt)
+	}
+	if rto := e.timeout(); rto < 200*time.Millisecond || rto > 300*time.Millisecond {
+		t.Errorf("unexpected RTO %v for a steady 200ms RTT", rto)
+	}
+
+	// Bounds
+	e = rttEstimator{}
+	e.sample(time.Millisecond)
+	if e.timeout() != minRTO {
+		t.Errorf("expected the RTO to be bounded by %v, got %v", minRTO, e.timeout())
+	}
+	e = rttEstimator{}
+	e.sample(time.Minute)
+	if e.timeout() != maxRTO {
+		t.Errorf("expected the RTO to be bounded by %v, got %v", maxRTO, e.timeout())
+	}
+}
+
+// trackSent tracks a message as if all of its fragments were sent, returning its outcome channel
+func trackSent(r *retransmitter, id uint32, fragments []dataFragment, now time.Time) <-chan error {
+	done := r.track(id, fragments)
+	for _, f := range fragments {
+		r.cwnd.acquire(fragmentCost(f), nil)
+		r.markSent(f, now)
+	}
+	return done
+}
+
+func TestRetransmitter_ACK(t *testing.T) {
+	r := newRetransmitter(newCongestionWindow(fragmentHeaderLen + 100))
+	fragments, _ := fragmentMessage(1, make([]byte, 300), 100)
+	now := time.Now()
+	done := trackSent(r, 1, fragments, now)
+
+	// Nothing is due before the RTO
+	if due := r.due(now.Add(initialRTO / 2)); len(due) != 0 {
+		t.Errorf("expected no fragment due, got %d", len(due))
+	}
+
+	// A partial ACK leaves the missing fragments to be resent
+	var received fragmentSet
+	received.add(0)
+	received.add(2)
+	r.ackPartial(1, received, now)
+	due := r.due(now.Add(initialRTO))
+	if len(due) != 1 || due[0].Num != 1 {
+		t.Fatalf("expected fragment 1 to be due, got %v", due)
+	}
+
+	// Then acknowledging the last one completes the message
+	received.add(1)
+	r.ackPartial(1, received, now.Add(initialRTO+10*time.Millisecond))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	default:
+		t.Fatal("message wasn't completed")
+	}
+
+	// As it was retransmitted, it doesn't give an RTT sample
+	if r.rtt.sampled {
+		t.Error("retransmitted message was sampled")
+	}
+
+	// Whereas a message acknowledged at once does
+	fragments, _ = fragmentMessage(2, make([]byte, 300), 100)
+	done = trackSent(r, 2, fragments, now)
+	r.ack(2, now.Add(50*time.Millisecond))
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if r.rtt.srtt != 50*time.Millisecond {
+		t.Errorf("expected a 50ms RTT sample, got %v", r.rtt.srtt)
+	}
+}
+
+func TestRetransmitter_Failure(t *testing.T) {
+	r := newRetransmitter(newCongestionWindow(fragmentHeaderLen + 100))
+	fragments, _ := fragmentMessage(1, make([]byte, 300), 100)
+	now := time.Now()
+	done := trackSent(r, 1, fragments, now)
+
+	// Each retransmission waits exponentially longer, until the message fails
+	var wait time.Duration
+	for attempt := 1; attempt < maxSendAttempts; attempt++ {
+		next := initialRTO << uint(attempt-1)
+		if next > maxRTO {
+			next = maxRTO
+		}
+		wait += next
+		if due := r.due(now.Add(wait - time.Millisecond)); len(due) != 0 {
+			t.Fatalf("attempt %d: fragments resent too early", attempt)
+		}
+		if due := r.due(now.Add(wait)); len(due) != len(fragments) {
+			t.Fatalf("attempt %d: expected %d fragments due, got %d", attempt, len(fragments), len(due))
+		}
+	}
+	wait += maxRTO
+	if due := r.due(now.Add(wait)); len(due) != 0 {
+		t.Errorf("expected no fragment resent after the last attempt, got %d", len(due))
+	}
+	if err := <-done; err != ErrDeliveryFailed {
+		t.Errorf("expected ErrDeliveryFailed, got %v", err)
+	}
+}
+
+func TestRetransmitter_Queued(t *testing.T) {
+	r := newRetransmitter(newCongestionWindow(fragmentHeaderLen + 100))
+	fragments, _ := fragmentMessage(1, make([]byte, 300), 100)
+	now := time.Now()
+	done := r.track(1, fragments)
+
+	// A message waiting for room in the window is neither resent nor failed, however long it waits
+	later := now.Add(time.Duration(maxSendAttempts) * maxRTO)
+	if due := r.due(later); len(due) != 0 {
+		t.Errorf("expected no fragment due, got %d", len(due))
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("message failed without being sent: %v", err)
+	default:
+	}
+
+	// Its fragments' timers start once they are sent
+	for _, f := range fragments {
+		r.markSent(f, later)
+	}
+	if due := r.due(later.Add(initialRTO - time.Millisecond)); len(due) != 0 {
+		t.Errorf("expected no fragment due before the RTO, got %d", len(due))
+	}
+
+	// And the time spent waiting isn't part of the RTT sample
+	r.ack(1, later.Add(50*time.Millisecond))
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if r.rtt.srtt != 50*time.Millisecond {
+		t.Errorf("expected a 50ms RTT sample, got %v", r.rtt.srtt)
+	}
+}
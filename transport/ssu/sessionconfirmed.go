@@ -0,0 +1,283 @@
+package ssu
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// sessionConfirmedMaxFragmentSize is the maximum size of an identity fragment, as in the current Java implementation
+	sessionConfirmedMaxFragmentSize = 512
+
+	// sessionConfirmedMaxFragments is the maximum number of identity fragments, as the total is a 4-bit integer
+	sessionConfirmedMaxFragments = 15
+)
+
+/*
+Fragment 0 through F-2 (only if F > 1):
+
+  +----+----+----+----+----+----+----+----+
+  |info| cursize |                        |
+  +----+----+----+                        +
+  |      fragment of Alice's full         |
+  ~            Router Identity            ~
+  ~                .  .  .                ~
+  |                                       |
+  +----+----+----+----+----+----+----+----+
+  | arbitrary amount of uninterpreted data|
+  ~                .  .  .                ~
+
+Fragment F-1 (last or only fragment):
+
+  +----+----+----+----+----+----+----+----+
+  |info| cursize |                        |
+  +----+----+----+                        +
+  |     last fragment of Alice's full     |
+  ~            Router Identity            ~
+  ~                .  .  .                ~
+  |                                       |
+  +----+----+----+----+----+----+----+----+
+  |  signed on time   |                   |
+  +----+----+----+----+                   +
+  |  arbitrary amount of uninterpreted    |
+  ~      data, until the signature at     ~
+  ~       end of the current packet       ~
+  |  Packet length must be mult. of 16    |
+  +----+----+----+----+----+----+----+----+
+  +                                       +
+  |                                       |
+  +                                       +
+  |             signature                 |
+  +                                       +
+  |                                       |
+  +                                       +
+  |                                       |
+  +----+----+----+----+----+----+----+----+
+
+The identity fragment info is:
+
+  Bit order: 76543210 (bit 7 is MSB)
+  bits 7-4: current identity fragment # 0-14
+  bits 3-0: total identity fragments (F) 1-15
+*/
+type sessionConfirmed struct {
+	// Alice's RouterIdentity
+	Identity []byte
+
+	// Time at which the exchanged data was signed, in seconds since the UNIX epoch
+	SignedOn uint32
+
+	// Alice's signature of the exchanged data
+	Signature []byte
+}
+
+// MarshalBinary marshals a sessionConfirmed to binary form, as a single identity fragment
+// If the identity is too large to fit in a single fragment, use marshalFragments
+func (sc *sessionConfirmed) MarshalBinary() ([]byte, error) {
+	if len(sc.Identity) > sessionConfirmedMaxFragmentSize {
+		return nil, errors.New("identity is too large to fit in a single fragment")
+	}
+
+	fragments, err := sc.marshalFragments(sessionConfirmedMaxFragmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return fragments[0], nil
+}
+
+// marshalFragments marshals a sessionConfirmed to binary form, splitting the identity in fragments of at most fragmentSize bytes
+// Each fragment is to be sent in its own datagram
+// As the signature is at the end of the last fragment, the padding is inserted before it so that the datagram's encrypted part is a multiple of 16 bytes
+func (sc *sessionConfirmed) marshalFragments(fragmentSize int) ([][]byte, error) {
+	// Sanity check
+	if fragmentSize <= 0 || fragmentSize > 1<<16-1 {
+		return nil, errors.New("invalid fragment size: cannot represent it in two bytes")
+	} else if len(sc.Signature) == 0 {
+		return nil, errors.New("session confirmed has no signature")
+	}
+
+	// Count the fragments
+	total := (len(sc.Identity) + fragmentSize - 1) / fragmentSize
+	if total == 0 {
+		total = 1
+	} else if total > sessionConfirmedMaxFragments {
+		return nil, fmt.Errorf("identity needs %d fragments, more than the maximum of %d", total, sessionConfirmedMaxFragments)
+	}
+
+	fragments := make([][]byte, total)
+	for i := range fragments {
+		// Extract the identity fragment
+		start := i * fragmentSize
+		end := start + fragmentSize
+		if end > len(sc.Identity) {
+			end = len(sc.Identity)
+		}
+		data := sc.Identity[start:end]
+		last := i == total-1
+
+		// Compute the length, the padding of non-last fragments being left to the datagram
+		unpadded := 1 + 2 + len(data)
+		padLen := 0
+		if last {
+			// Taking into account the flag & time of the datagram header
+			unpadded += 4 + len(sc.Signature)
+			padLen = (16 - (payloadPos-flagPos+unpadded)%16) % 16
+		}
+
+		// Create the slice
+		b := make([]byte, unpadded+padLen)
+
+		// Identity fragment info
+		b[0] = byte(i)<<4 | byte(total)
+
+		// Size of the fragment
+		binary.BigEndian.PutUint16(b[1:3], uint16(len(data)))
+
+		// Copy the identity fragment
+		cursor := 3
+		copy(b[cursor:], data)
+		cursor += len(data)
+
+		// Only the last fragment carries the signed-on time, the padding and the signature
+		if last {
+			// Copy the signed-on time
+			binary.BigEndian.PutUint32(b[cursor:cursor+4], sc.SignedOn)
+			cursor += 4
+
+			// Write random padding
+			_, err := rand.Read(b[cursor : cursor+padLen])
+			if err != nil {
+				return nil, err
+			}
+			cursor += padLen
+
+			// Copy the signature
+			copy(b[cursor:], sc.Signature)
+		}
+
+		fragments[i] = b
+	}
+
+	return fragments, nil
+}
+
+// UnmarshalBinary unmarshals a sessionConfirmed made of a single identity fragment
+// Fragmented identities must be reassembled with a sessionConfirmedReassembler
+// Does not retain b
+func (sc *sessionConfirmed) UnmarshalBinary(b []byte) error {
+	r := new(sessionConfirmedReassembler)
+	done, err := r.add(b)
+	if err != nil {
+		return err
+	} else if !done {
+		return errors.New("session confirmed is fragmented")
+	}
+
+	res, err := r.sessionConfirmed()
+	if err != nil {
+		return err
+	}
+	*sc = *res
+	return nil
+}
+
+// sessionConfirmedReassembler reassembles the identity fragments of a sessionConfirmed
+// Fragments may be added in any order, duplicates are ignored
+type sessionConfirmedReassembler struct {
+	// Total number of fragments, 0 until the first fragment is added
+	total byte
+
+	// Fragments received so far, and their count
+	fragments [sessionConfirmedMaxFragments][]byte
+	received  int
+
+	// What follows the last fragment: the signed-on time, padding and signature
+	tail []byte
+}
+
+// add adds a binary identity fragment, returning true once all fragments have been received
+// Does not retain b
+func (r *sessionConfirmedReassembler) add(b []byte) (bool, error) {
+	// Check for correct minimum len
+	if len(b) < 1+2 {
+		return false, errors.New("session confirmed is invalid: too small")
+	}
+
+	// Check the identity fragment info
+	num, total := b[0]>>4, b[0]&0x0F
+	if total == 0 || num >= total {
+		return false, fmt.Errorf("invalid identity fragment info: fragment %d of %d", num, total)
+	} else if r.total != 0 && total != r.total {
+		return false, fmt.Errorf("inconsistent total of identity fragments: %d instead of %d", total, r.total)
+	}
+	r.total = total
+
+	// Ignore duplicates
+	if r.fragments[num] != nil {
+		return r.received == int(r.total), nil
+	}
+
+	// Extract the identity fragment
+	size := int(binary.BigEndian.Uint16(b[1:3]))
+	cursor := 3
+	if len(b) < cursor+size {
+		return false, errors.New("session confirmed is invalid: too small for identity fragment")
+	}
+	fragment := make([]byte, size)
+	copy(fragment, b[cursor:cursor+size])
+	cursor += size
+
+	// The last fragment is followed by the signed-on time, padding and signature
+	if num == total-1 {
+		if len(b) < cursor+4 {
+			return false, errors.New("session confirmed is invalid: too small for signed-on time")
+		}
+		r.tail = make([]byte, len(b)-cursor)
+		copy(r.tail, b[cursor:])
+	}
+
+	// Store it
+	r.fragments[num] = fragment
+	r.received++
+
+	return r.received == int(r.total), nil
+}
+
+// sessionConfirmed returns the reassembled sessionConfirmed
+// The length of the signature is implied by the reassembled identity
+func (r *sessionConfirmedReassembler) sessionConfirmed() (*sessionConfirmed, error) {
+	if r.total == 0 || r.received != int(r.total) {
+		return nil, errors.New("session confirmed is incomplete")
+	}
+
+	// Reassemble the identity
+	size := 0
+	for _, fragment := range r.fragments[:r.total] {
+		size += len(fragment)
+	}
+	identity := make([]byte, 0, size)
+	for _, fragment := range r.fragments[:r.total] {
+		identity = append(identity, fragment...)
+	}
+
+	// Deduce the signature length from it
+	sigLen, err := identitySignatureSize(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is at the very end, after the padding
+	if len(r.tail) < 4+sigLen {
+		return nil, errors.New("session confirmed is invalid: too small for signature")
+	}
+	sc := &sessionConfirmed{
+		Identity:  identity,
+		SignedOn:  binary.BigEndian.Uint32(r.tail[:4]),
+		Signature: make([]byte, sigLen),
+	}
+	copy(sc.Signature, r.tail[len(r.tail)-sigLen:])
+
+	return sc, nil
+}
@@ -0,0 +1,156 @@
+package ssu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestIdentity creates a DSA-SHA1 RouterIdentity of the given size, using a HashCash certificate to fill it
+func newTestIdentity(t *testing.T, size int) []byte {
+	if size < identityMinSize {
+		t.Fatalf("identity size %d smaller than minimum %d", size, identityMinSize)
+	}
+	identity := make([]byte, size)
+	_, err := rand.Read(identity[:identityPublicKeySize+identitySigningKeySize])
+	if err != nil {
+		t.Fatalf("couldn't generate keys: %v", err)
+	}
+	identity[identityPublicKeySize+identitySigningKeySize] = 1
+	binary.BigEndian.PutUint16(identity[identityMinSize-2:identityMinSize], uint16(size-identityMinSize))
+	return identity
+}
+
+// TestSessionConfirmed_MarshallingCoherence tests sessionConfirmed marshalling followed by reassembly, for identities of various sizes
+func TestSessionConfirmed_MarshallingCoherence(t *testing.T) {
+	var tests = []struct {
+		identitySize int
+		fragmentSize int
+		fragments    int
+	}{
+		{identityMinSize, sessionConfirmedMaxFragmentSize, 1},
+		{sessionConfirmedMaxFragmentSize, sessionConfirmedMaxFragmentSize, 1},
+		{sessionConfirmedMaxFragmentSize + 1, sessionConfirmedMaxFragmentSize, 2},
+		{1200, sessionConfirmedMaxFragmentSize, 3},
+		{identityMinSize, 32, 13},
+		{sessionConfirmedMaxFragments * 32, 32, 15},
+	}
+
+	for _, test := range tests {
+		origin := &sessionConfirmed{
+			Identity:  newTestIdentity(t, test.identitySize),
+			SignedOn:  0xDEADBEEF,
+			Signature: make([]byte, dsaSignatureSize),
+		}
+		rand.Read(origin.Signature)
+
+		fragments, err := origin.marshalFragments(test.fragmentSize)
+		if err != nil {
+			t.Errorf("[%d/%d] error in marshalFragments: %v", test.identitySize, test.fragmentSize, err)
+			continue
+		} else if len(fragments) != test.fragments {
+			t.Errorf("[%d/%d] got %d fragments instead of %d", test.identitySize, test.fragmentSize, len(fragments), test.fragments)
+		}
+
+		// The last fragment's datagram must not need any padding, as the signature is at its end
+		last := fragments[len(fragments)-1]
+		if (payloadPos-flagPos+len(last))%16 != 0 {
+			t.Errorf("[%d/%d] last fragment of len %d is not padded to a 16 bytes boundary", test.identitySize, test.fragmentSize, len(last))
+		}
+
+		// Reassemble in reverse order, adding a duplicate
+		r := new(sessionConfirmedReassembler)
+		for i := len(fragments) - 1; i >= 0; i-- {
+			done, err := r.add(fragments[i])
+			if err != nil {
+				t.Fatalf("[%d/%d] error adding fragment %d: %v", test.identitySize, test.fragmentSize, i, err)
+			} else if done != (i == 0) {
+				t.Errorf("[%d/%d] reassembler done = %v after fragment %d", test.identitySize, test.fragmentSize, done, i)
+			}
+			if i == len(fragments)-1 {
+				if _, err := r.add(fragments[i]); err != nil {
+					t.Errorf("[%d/%d] error adding duplicate fragment: %v", test.identitySize, test.fragmentSize, err)
+				}
+			}
+		}
+		destination, err := r.sessionConfirmed()
+		if err != nil {
+			t.Fatalf("[%d/%d] error in reassembly: %v", test.identitySize, test.fragmentSize, err)
+		}
+
+		if !bytes.Equal(origin.Identity, destination.Identity) {
+			t.Errorf("[%d/%d] identities differ", test.identitySize, test.fragmentSize)
+		}
+		if origin.SignedOn != destination.SignedOn {
+			t.Errorf("[%d/%d] signed-on times differ: %d != %d", test.identitySize, test.fragmentSize, origin.SignedOn, destination.SignedOn)
+		}
+		if !bytes.Equal(origin.Signature, destination.Signature) {
+			t.Errorf("[%d/%d] signatures differ: %v != %v", test.identitySize, test.fragmentSize, origin.Signature, destination.Signature)
+		}
+	}
+}
+
+// TestSessionConfirmed_TooManyFragments tests that an identity needing more than 15 fragments is rejected
+func TestSessionConfirmed_TooManyFragments(t *testing.T) {
+	sc := &sessionConfirmed{
+		Identity:  newTestIdentity(t, sessionConfirmedMaxFragments*32+1),
+		Signature: make([]byte, dsaSignatureSize),
+	}
+	if _, err := sc.marshalFragments(32); err == nil {
+		t.Errorf("marshalFragments accepted an identity needing %d fragments", sessionConfirmedMaxFragments+1)
+	}
+}
+
+// TestSessionConfirmed_InconsistentTotal tests that fragments disagreeing on the total are rejected
+func TestSessionConfirmed_InconsistentTotal(t *testing.T) {
+	sc := &sessionConfirmed{
+		Identity:  newTestIdentity(t, 1200),
+		Signature: make([]byte, dsaSignatureSize),
+	}
+	fragments, err := sc.marshalFragments(sessionConfirmedMaxFragmentSize)
+	if err != nil {
+		t.Fatalf("error in marshalFragments: %v", err)
+	}
+
+	// Tamper with the total of the second fragment
+	fragments[1][0] = fragments[1][0]&0xF0 | 4
+
+	r := new(sessionConfirmedReassembler)
+	if _, err := r.add(fragments[0]); err != nil {
+		t.Fatalf("error adding fragment 0: %v", err)
+	}
+	if _, err := r.add(fragments[1]); err == nil {
+		t.Errorf("fragment with inconsistent total accepted")
+	}
+}
+
+// TestSessionConfirmed_SigTypes tests that the signature length of a reassembled sessionConfirmed follows from the identity
+func TestSessionConfirmed_SigTypes(t *testing.T) {
+	for _, st := range testSigTypes {
+		signer, identity := newTestSigner(t, st)
+		sig, err := signer.Sign([]byte("this is the signed data"))
+		if err != nil {
+			t.Fatalf("[%d] error in Sign: %v", st, err)
+		}
+		origin := &sessionConfirmed{
+			Identity:  identity,
+			Signature: sig,
+		}
+
+		b, err := origin.MarshalBinary()
+		if err != nil {
+			t.Fatalf("[%d] error in MarshalBinary: %v", st, err)
+		} else if (payloadPos-flagPos+len(b))%16 != 0 {
+			t.Errorf("[%d] session confirmed of len %d is not padded to a 16 bytes boundary", st, len(b))
+		}
+
+		destination := new(sessionConfirmed)
+		if err := destination.UnmarshalBinary(b); err != nil {
+			t.Fatalf("[%d] error in UnmarshalBinary: %v", st, err)
+		}
+		if !bytes.Equal(origin.Signature, destination.Signature) {
+			t.Errorf("[%d] signatures differ: %v != %v", st, origin.Signature, destination.Signature)
+		}
+	}
+}
@@ -2,19 +2,20 @@ package ssu
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha1"
 	"encoding/binary"
-	"io"
 	"net"
 	"time"
 
 	"errors"
+	"fmt"
 )
 
 const (
 	reasonableMaxSCSize = 400
-	minSCSize           = 256 + 1 + 16 + 2 + 4 + 4 // + signature ?
+	minSCSize           = 256 + 1 + 4 + 2 + 4 + 4 // without the signature
 )
 
 type sessionCreated struct {
@@ -27,15 +28,28 @@ type sessionCreated struct {
 	// Relay tag if applicable
 	RelayTag [4]byte
 
+	// Time at which the exchanged data was signed, in seconds since the UNIX epoch
+	SignedOn uint32
+
+	// Signature over the exchanged data, still encrypted with the session key once unmarshalled
+	// It is followed by its padding and any uninterpreted data
+	Signature []byte
+
 	// Information to be signed
 	X      [256]byte
 	MyAddr net.UDPAddr
 
-	// SessionKey to encrypt the signature
-	RemoteIntroKey []byte
+	// Signer of the exchanged data when marshalling
+	Signer Signer
+
+	// SessionKey & datagram IV to encrypt the signature
+	SessionKey []byte
+	IV         []byte
 }
 
 /* MarshalBinary marshals a sessionCreated to binary form
+The exchanged data is signed with Signer, and the signature is encrypted with SessionKey & IV,
+the latter having to be the IV of the datagram which will carry the sessionCreated
 
 +----+----+----+----+----+----+----+----+
 |         Y, as calculated from DH      |
@@ -68,8 +82,14 @@ func (sc *sessionCreated) MarshalBinary() ([]byte, error) {
 	// Sanity check for sessionCreated
 	if len(sc.Addr.IP) != 4 && len(sc.Addr.IP) != 16 {
 		return nil, errors.New("invalid IP address length")
-	} else if sc.Addr.Port > 1<<16-1 {
+	} else if sc.Addr.Port > 1<<16-1 || sc.MyAddr.Port > 1<<16-1 {
 		return nil, errors.New("port overflows uint16: cannot represent it in two bytes")
+	} else if len(sc.MyAddr.IP) != 4 && len(sc.MyAddr.IP) != 16 {
+		return nil, errors.New("invalid own IP address length")
+	} else if len(sc.IV) != aes.BlockSize {
+		return nil, errors.New("invalid IV len")
+	} else if sc.Signer == nil {
+		return nil, errors.New("no signer given")
 	}
 
 	// Create the bytes buffer we will use
@@ -90,77 +110,175 @@ func (sc *sessionCreated) MarshalBinary() ([]byte, error) {
 	// Write the public relay tag
 	buf.Write(sc.RelayTag[:])
 
+	// Write the signed-on time
+	binary.Write(buf, binary.BigEndian, sc.SignedOn)
+
 	// Create the signature
 
-	// First we hash it all
-	t := time.Now()
-	hash := sessionCreatedHash(&sc.X, &sc.Y, sc.Addr.IP, uint16(sc.Addr.Port), sc.MyAddr.IP, uint16(sc.MyAddr.Port), &sc.RelayTag, t)
+	// First we gather it all
+	data := sessionSignedData(&sc.X, &sc.Y, sc.Addr.IP, uint16(sc.Addr.Port), sc.MyAddr.IP, uint16(sc.MyAddr.Port), &sc.RelayTag, time.Unix(int64(sc.SignedOn), 0))
+
+	// Then we sign, the padding following from the signature type
+	signed, err := sc.Signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pad it and encrypt it with the session key
+	sigBlock, err := sc.encryptSignature(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write it
+	buf.Write(sigBlock)
+
+	// done
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary unmarshals a sessionCreated from its binary form
+// The signature is left encrypted, see decryptSignature
+// Does not retain b
+func (sc *sessionCreated) UnmarshalBinary(b []byte) error {
+	// Check for correct minimum len
+	if len(b) < minSCSize {
+		return errors.New("session created is invalid: too small")
+	} else if b[256] != 4 && b[256] != 16 {
+		return fmt.Errorf("IP size indicator is neither 4 nor 16 but %d", b[256])
+	}
+
+	// The first 256 bytes are the Y
+	copy(sc.Y[:], b[:256])
+
+	// Check for the size of the IP
+	ipLen := int(b[256])
+	cursor := 257
+	if len(b) < cursor+ipLen+2+4+4 {
+		return errors.New("session created is invalid: too small")
+	}
+
+	// Copy the IP
+	sc.Addr.IP = make(net.IP, ipLen)
+	copy(sc.Addr.IP, b[cursor:cursor+ipLen])
+	cursor += ipLen
+
+	// Copy the port
+	sc.Addr.Port = int(binary.BigEndian.Uint16(b[cursor : cursor+2]))
+	cursor += 2
+
+	// Copy the relay tag
+	copy(sc.RelayTag[:], b[cursor:cursor+4])
+	cursor += 4
 
-	// Then we sign
-	signed := sessionCreatedSign(hash, make([]byte, 4)) //TODO HASH
+	// Copy the signed-on time
+	sc.SignedOn = binary.BigEndian.Uint32(b[cursor : cursor+4])
+	cursor += 4
 
+	// What remains is the encrypted signature, its padding and uninterpreted data
+	sc.Signature = make([]byte, len(b)-cursor)
+	copy(sc.Signature, b[cursor:])
+
+	// Finished
+	return nil
+}
+
+// signatureBlockLen returns the length of a signature padded to a multiple of 16 bytes
+func signatureBlockLen(sigLen int) int {
+	return sigLen + (16-sigLen%16)%16
+}
+
+// encryptSignature pads the signature with random data to a multiple of 16 bytes, then encrypts it
+// with an additional layer of encryption using the session key, reusing the IV of the datagram
+func (sc *sessionCreated) encryptSignature(signed []byte) ([]byte, error) {
 	// If the signature length is not a multiple of 16, pad it until it is.
-	padLen := 16 - (1 + 4 + len(signed)%16)
-	if padLen == 16 {
-		padLen = 0
+	sigBlock := make([]byte, signatureBlockLen(len(signed)))
+	copy(sigBlock, signed)
+	_, err := rand.Read(sigBlock[len(signed):])
+	if err != nil {
+		return nil, err
 	}
-	n, err := io.CopyN(buf, rand.Reader, int64(padLen))
+
+	// Let's create the AES cipher with the session key
+	c, err := aes.NewCipher(sc.SessionKey)
 	if err != nil {
 		return nil, err
+	} else if len(sc.IV) != aes.BlockSize {
+		return nil, errors.New("invalid IV len")
+	}
+
+	// Let's transform it into a CBC cipher encrypter, reusing the datagram's IV
+	enc := cipher.NewCBCEncrypter(c, sc.IV)
+
+	// Encrypt the signature and its padding, in-place
+	enc.CryptBlocks(sigBlock, sigBlock)
+
+	return sigBlock, nil
+}
+
+// decryptSignature decrypts the signature of an unmarshalled sessionCreated, given its expected length
+// SessionKey must be set to the negotiated session key, and IV to the IV of the datagram carrying the sessionCreated
+func (sc *sessionCreated) decryptSignature(sigLen int) ([]byte, error) {
+	// The signature is padded to a multiple of 16 bytes
+	encLen := signatureBlockLen(sigLen)
+	if len(sc.Signature) < encLen {
+		return nil, errors.New("session created is invalid: signature too small")
 	}
-	if n != int64(padLen) {
-		return nil, errors.New("didn't read the exact amount of random padding into sc buffer")
+
+	// Let's create the AES cipher with the session key
+	c, err := aes.NewCipher(sc.SessionKey)
+	if err != nil {
+		return nil, err
+	} else if len(sc.IV) != aes.BlockSize {
+		return nil, errors.New("invalid IV len")
 	}
 
-	// Extract the bytes and push the buf back to the global pool
-	b := buf.Bytes()
-	buf.Reset()
+	// Let's transform it into a CBC cipher decrypter, reusing the datagram's IV
+	dec := cipher.NewCBCDecrypter(c, sc.IV)
 
-	// done
-	return b, errors.New("Not completely implemented")
+	// Decrypt the signature and its padding
+	tmp := make([]byte, encLen)
+	dec.CryptBlocks(tmp, sc.Signature[:encLen])
+
+	// Strip the padding
+	return tmp[:sigLen], nil
 }
 
-func sessionCreatedHash(X *[256]byte, Y *[256]byte, reqIP net.IP, reqPort uint16, respIP net.IP, respPort uint16, relayTag *[4]byte, time time.Time) []byte {
-	// X + Y + Alice's IP + Alice's port + Bob's IP + Bob's port + Alice's new relay tag + Bob's signed on time (We are bob, remote is alice)
-	// This is DSA-SHA1, so we must first hash that data, and then sign it
+// sessionSignedData returns the data signed in both the sessionCreated and the sessionConfirmed
+// The Signer or Verifier hashes it if its algorithm requires it
+func sessionSignedData(X *[256]byte, Y *[256]byte, reqIP net.IP, reqPort uint16, respIP net.IP, respPort uint16, relayTag *[4]byte, time time.Time) []byte {
+	// X + Y + Alice's IP + Alice's port + Bob's IP + Bob's port + Alice's new relay tag + signed on time
 
-	// Let's create the hasher
-	hasher := sha1.New()
+	// Let's create the buffer
+	buf := bytes.NewBuffer(make([]byte, 0, 256+256+len(reqIP)+2+len(respIP)+2+4+4))
 
 	// Let's establish the time
 	timeU := uint32(time.Unix())
 
 	// Copy X
-	hasher.Write(X[:])
+	buf.Write(X[:])
 
 	// Copy Y
-	hasher.Write(Y[:])
+	buf.Write(Y[:])
 
 	// Copy reqIP
-	hasher.Write(reqIP)
+	buf.Write(reqIP)
 
 	// Copy reqPort
-	binary.Write(hasher, binary.BigEndian, reqPort)
+	binary.Write(buf, binary.BigEndian, reqPort)
 
 	// Copy respIP
-	hasher.Write(respIP)
+	buf.Write(respIP)
 
 	// Copy respPort
-	binary.Write(hasher, binary.BigEndian, respPort)
+	binary.Write(buf, binary.BigEndian, respPort)
 
 	// Copy relay tag
-	hasher.Write(relayTag[:])
+	buf.Write(relayTag[:])
 
 	// Copy time (uint32 = 4 bytes)
-	binary.Write(hasher, binary.BigEndian, timeU)
-
-	// Sum it
-	sum := hasher.Sum(nil)
+	binary.Write(buf, binary.BigEndian, timeU)
 
 	// Return
-	return sum
-}
-
-func sessionCreatedSign(hashed []byte, privKey []byte) []byte {
-	return nil
+	return buf.Bytes()
 }
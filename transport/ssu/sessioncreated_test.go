@@ -0,0 +1,121 @@
+package ssu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSessionCreated_MarshallingCoherence tests sessionCreated marshalling inside a datagram followed by unmarshalling,
+// checking that the signature can be decrypted and verified by the receiver, for every signature type
+func TestSessionCreated_MarshallingCoherence(t *testing.T) {
+	var tests = []struct {
+		aliceAddr net.UDPAddr
+		bobAddr   net.UDPAddr
+	}{
+		{net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 1234}, net.UDPAddr{IP: net.IPv4(198, 51, 100, 1).To4(), Port: 4321}},
+		{net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 4321}},
+	}
+
+	for _, st := range testSigTypes {
+		for _, test := range tests {
+			testSessionCreatedMarshallingCoherence(t, newTestDialerWithSigType(t, st), test.aliceAddr, test.bobAddr)
+		}
+	}
+}
+
+// testSessionCreatedMarshallingCoherence marshals a sessionCreated signed by bob, then unmarshals and checks it
+func testSessionCreatedMarshallingCoherence(t *testing.T, bob *Dialer, aliceAddr net.UDPAddr, bobAddr net.UDPAddr) {
+	verifier, err := identityVerifier(bob.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in identityVerifier: %v", err)
+	}
+
+	// Create the keys
+	sessionKey := make([]byte, sessionKeySize)
+	rand.Read(sessionKey)
+	iv := make([]byte, flagPos-ivPos)
+	rand.Read(iv)
+
+	origin := &sessionCreated{
+		Addr:       aliceAddr,
+		RelayTag:   [4]byte{1, 2, 3, 4},
+		SignedOn:   uint32(time.Now().Unix()),
+		MyAddr:     bobAddr,
+		Signer:     bob.Signer,
+		SessionKey: sessionKey,
+		IV:         iv,
+	}
+	rand.Read(origin.X[:])
+	rand.Read(origin.Y[:])
+
+	// Marshal it
+	b, err := origin.MarshalBinary()
+	if err != nil {
+		t.Fatalf("[%v] error in MarshalBinary: %v", aliceAddr, err)
+	}
+
+	// Embed it in a datagram
+	d := &datagram{
+		Flag:    composeFlag(payloadSessionCreated, false, false),
+		Time:    origin.SignedOn,
+		IV:      iv,
+		Payload: b,
+	}
+	db, err := d.MarshalBinary(bob.Introkey, bob.Introkey)
+	if err != nil {
+		t.Fatalf("[%v] error in datagram MarshalBinary: %v", aliceAddr, err)
+	}
+
+	// Unmarshal the datagram
+	dd := new(datagram)
+	err = dd.unmarshal(db, bob.Introkey, bob.Introkey)
+	if err != nil {
+		t.Fatalf("[%v] error in datagram unmarshal: %v", aliceAddr, err)
+	}
+
+	// Unmarshal the sessionCreated
+	destination := new(sessionCreated)
+	err = destination.UnmarshalBinary(dd.Payload)
+	if err != nil {
+		t.Fatalf("[%v] error in UnmarshalBinary: %v", aliceAddr, err)
+	}
+	if destination.Y != origin.Y {
+		t.Errorf("[%v] Y differ", aliceAddr)
+	}
+	if !destination.Addr.IP.Equal(origin.Addr.IP) || destination.Addr.Port != origin.Addr.Port {
+		t.Errorf("[%v] addresses differ: %v != %v", aliceAddr, destination.Addr, origin.Addr)
+	}
+	if destination.RelayTag != origin.RelayTag {
+		t.Errorf("[%v] relay tags differ: %v != %v", aliceAddr, destination.RelayTag, origin.RelayTag)
+	}
+	if destination.SignedOn != origin.SignedOn {
+		t.Errorf("[%v] signed-on times differ: %d != %d", aliceAddr, destination.SignedOn, origin.SignedOn)
+	}
+
+	// Decrypt the signature
+	destination.SessionKey = sessionKey
+	destination.IV = dd.IV
+	sig, err := destination.decryptSignature(verifier.SignatureSize())
+	if err != nil {
+		t.Fatalf("[%v] error in decryptSignature: %v", aliceAddr, err)
+	}
+
+	// And verify it
+	data := sessionSignedData(&origin.X, &destination.Y, destination.Addr.IP, uint16(destination.Addr.Port), bobAddr.IP, uint16(bobAddr.Port), &destination.RelayTag, time.Unix(int64(destination.SignedOn), 0))
+	if !verifier.Verify(data, sig) {
+		t.Errorf("[%v] signature is invalid", aliceAddr)
+	}
+
+	// With the wrong session key, the signature is garbage
+	rand.Read(destination.SessionKey)
+	garbage, err := destination.decryptSignature(verifier.SignatureSize())
+	if err != nil {
+		t.Fatalf("[%v] error in decryptSignature: %v", aliceAddr, err)
+	}
+	if bytes.Equal(garbage, sig) {
+		t.Errorf("[%v] signature decrypted with the wrong key", aliceAddr)
+	}
+}
@@ -1,6 +1,7 @@
 package ssu
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -60,14 +61,56 @@ func (sr *sessionRequest) UnmarshalBinary(b []byte) error {
 	// Check for the size of the IP
 	switch b[256] {
 	case 4:
-		copy(sr.IP[:], b[257:257+4])
+		sr.IP = make(net.IP, 4)
+		copy(sr.IP, b[257:257+4])
 	case 16:
 		if len(b) < 256+1+16 {
 			return errors.New("session request is invalid: too small")
 		}
-		copy(sr.IP[:], b[257:257+16])
+		sr.IP = make(net.IP, 16)
+		copy(sr.IP, b[257:257+16])
 	}
 
 	// Finished
 	return nil
 }
+
+// relayTagRequestedFlag is the bit of the session request options' flags by which Alice requests a relay tag
+const relayTagRequestedFlag = 1 << 0
+
+/*
+The extended options of a session request, since release 0.9.24
+
++----+----+
+|  flags  |
++----+----+
+
+Bit order: 15...76543210 (bit 15 is MSB)
+     bit 0: 1 for Alice to request a relay tag from Bob
+ bits 15-1: unused, set to 0 for compatibility with future uses
+*/
+type sessionRequestOptions struct {
+	// Whether Alice requests a relay tag from Bob in the session created message
+	// Without extended options, Alice is considered to request one
+	RelayTagRequested bool
+}
+
+func (so *sessionRequestOptions) MarshalBinary() ([]byte, error) {
+	var flags uint16
+	if so.RelayTagRequested {
+		flags |= relayTagRequestedFlag
+	}
+	return binary.BigEndian.AppendUint16(nil, flags), nil
+}
+
+// Does not retain b
+func (so *sessionRequestOptions) UnmarshalBinary(b []byte) error {
+	if len(b) < 2 {
+		return errors.New("session request options are invalid: too small")
+	}
+	flags := binary.BigEndian.Uint16(b)
+	so.RelayTagRequested = flags&relayTagRequestedFlag != 0
+
+	// Any following data is uninterpreted
+	return nil
+}
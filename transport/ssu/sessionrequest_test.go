@@ -0,0 +1,34 @@
+package ssu
+
+import (
+	"testing"
+)
+
+func TestSessionRequestOptions(t *testing.T) {
+	for _, requested := range []bool{false, true} {
+		b, err := (&sessionRequestOptions{RelayTagRequested: requested}).MarshalBinary()
+		if err != nil {
+			t.Fatalf("error while marshalling: %v", err)
+		}
+		if len(b) != 2 {
+			t.Errorf("marshalled to %d bytes, expected 2", len(b))
+		}
+
+		opts := new(sessionRequestOptions)
+		if err := opts.UnmarshalBinary(b); err != nil {
+			t.Fatalf("error while unmarshalling: %v", err)
+		}
+		if opts.RelayTagRequested != requested {
+			t.Errorf("relay tag requested %t, expected %t", opts.RelayTagRequested, requested)
+		}
+	}
+
+	// The flag is the least significant bit, the others being ignored
+	opts := new(sessionRequestOptions)
+	if err := opts.UnmarshalBinary([]byte{0xff, 0xfe, 0x01}); err != nil || opts.RelayTagRequested {
+		t.Errorf("unexpected options %+v (error %v)", opts, err)
+	}
+	if err := opts.UnmarshalBinary([]byte{0x01}); err == nil {
+		t.Error("no error when unmarshalling truncated options")
+	}
+}
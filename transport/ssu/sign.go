@@ -0,0 +1,65 @@
+package ssu
+
+import (
+	"fmt"
+)
+
+// A Signer signs the data exchanged during session establishment, with our signing private key
+// Its algorithm must match the signing public key published in our RouterIdentity
+type Signer interface {
+	// Sign signs the given data, hashing it first if the algorithm requires it
+	Sign(data []byte) ([]byte, error)
+
+	// SignatureSize returns the length of the signatures
+	SignatureSize() int
+}
+
+// A Verifier checks the signature of the data exchanged during session establishment, with a peer's signing public key
+type Verifier interface {
+	// Verify checks the signature of the given data, hashing it first if the algorithm requires it
+	Verify(data []byte, sig []byte) bool
+
+	// SignatureSize returns the length of the signatures
+	SignatureSize() int
+}
+
+// sigType is a signature type, as specified by the key certificate of a RouterIdentity
+type sigType uint16
+
+const (
+	// Signature types, as in the common structures specification
+	// The RSA types are not supported, as they are not used by routers
+	sigTypeDSASHA1            sigType = 0
+	sigTypeECDSASHA256P256    sigType = 1
+	sigTypeECDSASHA384P384    sigType = 2
+	sigTypeECDSASHA512P521    sigType = 3
+	sigTypeEdDSASHA512Ed25519 sigType = 7
+)
+
+// publicKeySize returns the length of the signing public keys of this type
+func (st sigType) publicKeySize() (int, error) {
+	switch st {
+	case sigTypeDSASHA1:
+		return dsaPublicKeySize, nil
+	case sigTypeECDSASHA256P256, sigTypeECDSASHA384P384, sigTypeECDSASHA512P521:
+		return 2 * ecdsaParameters[st].fieldSize, nil
+	case sigTypeEdDSASHA512Ed25519:
+		return ed25519PublicKeySize, nil
+	default:
+		return 0, fmt.Errorf("unsupported signature type %d", st)
+	}
+}
+
+// newVerifier returns a Verifier for the given signature type and signing public key
+func newVerifier(st sigType, pubKey []byte) (Verifier, error) {
+	switch st {
+	case sigTypeDSASHA1:
+		return newDSAVerifier(pubKey)
+	case sigTypeECDSASHA256P256, sigTypeECDSASHA384P384, sigTypeECDSASHA512P521:
+		return newECDSAVerifier(st, pubKey)
+	case sigTypeEdDSASHA512Ed25519:
+		return newEd25519Verifier(pubKey)
+	default:
+		return nil, fmt.Errorf("unsupported signature type %d", st)
+	}
+}
@@ -0,0 +1,139 @@
+package ssu
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+// testSigTypes are the signature types we support
+var testSigTypes = []sigType{
+	sigTypeDSASHA1,
+	sigTypeECDSASHA256P256,
+	sigTypeECDSASHA384P384,
+	sigTypeECDSASHA512P521,
+	sigTypeEdDSASHA512Ed25519,
+}
+
+// newTestSigner creates a Signer of the given type along with a matching binary RouterIdentity
+func newTestSigner(t *testing.T, st sigType) (Signer, []byte) {
+	var (
+		signer Signer
+		pubKey []byte
+		err    error
+	)
+
+	// Create the key pair
+	switch st {
+	case sigTypeDSASHA1:
+		privKey := make([]byte, dsaPrivateKeySize)
+		_, err = rand.Read(privKey)
+		if err != nil {
+			t.Fatalf("couldn't generate private key: %v", err)
+		}
+		pubKey = make([]byte, dsaPublicKeySize)
+		new(big.Int).Exp(dsaParameters.G, new(big.Int).SetBytes(privKey), dsaParameters.P).FillBytes(pubKey)
+		signer, err = NewDSASigner(privKey)
+	case sigTypeECDSASHA256P256, sigTypeECDSASHA384P384, sigTypeECDSASHA512P521:
+		params := ecdsaParameters[st]
+		var priv *ecdsa.PrivateKey
+		priv, err = ecdsa.GenerateKey(params.curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("couldn't generate private key: %v", err)
+		}
+		pubKey = make([]byte, 2*params.fieldSize)
+		priv.X.FillBytes(pubKey[:params.fieldSize])
+		priv.Y.FillBytes(pubKey[params.fieldSize:])
+		signer, err = NewECDSASigner(priv)
+	case sigTypeEdDSASHA512Ed25519:
+		var pub ed25519.PublicKey
+		var priv ed25519.PrivateKey
+		pub, priv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("couldn't generate private key: %v", err)
+		}
+		pubKey = pub
+		signer, err = NewEd25519Signer(priv)
+	}
+	if err != nil {
+		t.Fatalf("couldn't create signer: %v", err)
+	}
+
+	// Create the identity, with a random encryption key
+	excess := 0
+	if len(pubKey) > identitySigningKeySize {
+		excess = len(pubKey) - identitySigningKeySize
+	}
+	identity := make([]byte, identityPublicKeySize+identitySigningKeySize)
+	_, err = rand.Read(identity)
+	if err != nil {
+		t.Fatalf("couldn't generate public key: %v", err)
+	}
+	if excess == 0 {
+		copy(identity[identityPublicKeySize+identitySigningKeySize-len(pubKey):], pubKey)
+	} else {
+		copy(identity[identityPublicKeySize:], pubKey[:identitySigningKeySize])
+	}
+
+	// DSA-SHA1 uses a null certificate, the others a key certificate
+	if st == sigTypeDSASHA1 {
+		return signer, append(identity, certTypeNull, 0, 0)
+	}
+	cert := make([]byte, identityCertHeaderSize+4+excess)
+	cert[0] = certTypeKey
+	binary.BigEndian.PutUint16(cert[1:3], uint16(4+excess))
+	binary.BigEndian.PutUint16(cert[3:5], uint16(st))
+	copy(cert[7:], pubKey[len(pubKey)-excess:])
+	return signer, append(identity, cert...)
+}
+
+// TestSign_Coherence tests that the signatures made with a Signer are verified with the Verifier of its identity, for every signature type
+func TestSign_Coherence(t *testing.T) {
+	for _, st := range testSigTypes {
+		signer, identity := newTestSigner(t, st)
+
+		// Check the identity
+		gotType, err := identitySigType(identity)
+		if err != nil {
+			t.Fatalf("[%d] error in identitySigType: %v", st, err)
+		} else if gotType != st {
+			t.Errorf("[%d] identity is of signature type %d", st, gotType)
+		}
+		verifier, err := identityVerifier(identity)
+		if err != nil {
+			t.Fatalf("[%d] error in identityVerifier: %v", st, err)
+		}
+
+		// Sign
+		data := []byte("this is the signed data")
+		sig, err := signer.Sign(data)
+		if err != nil {
+			t.Fatalf("[%d] error in Sign: %v", st, err)
+		} else if len(sig) != signer.SignatureSize() || len(sig) != verifier.SignatureSize() {
+			t.Errorf("[%d] signature len (%d) differs from expected len (signer: %d, verifier: %d)", st, len(sig), signer.SignatureSize(), verifier.SignatureSize())
+		}
+
+		// Verify
+		if !verifier.Verify(data, sig) {
+			t.Errorf("[%d] valid signature rejected", st)
+		}
+
+		// Verify tampered data
+		data[0] ^= 0xFF
+		if verifier.Verify(data, sig) {
+			t.Errorf("[%d] signature over tampered data accepted", st)
+		}
+	}
+}
+
+// TestSign_UnsupportedType tests that an identity with an unsupported signature type is rejected
+func TestSign_UnsupportedType(t *testing.T) {
+	_, identity := newTestSigner(t, sigTypeEdDSASHA512Ed25519)
+	binary.BigEndian.PutUint16(identity[identityMinSize:identityMinSize+2], 4) // RSA-SHA256-2048
+	if _, err := identityVerifier(identity); err == nil {
+		t.Errorf("identity with unsupported signature type accepted")
+	}
+}
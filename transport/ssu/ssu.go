@@ -9,6 +9,7 @@ const (
 	payloadSessionCreated
 	payloadSessionConfirmed
 	payloadRelayRequest
+	payloadRelayResponse
 	payloadRelayIntro
 	payloadData
 	payloadPeerTest
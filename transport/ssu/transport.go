@@ -0,0 +1,300 @@
+package ssu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Transport carries all the SSU sessions of a router over a single UDP socket
+// Sessions are both dialed and accepted through it
+type Transport struct {
+	opts *Dialer
+	pc   net.PacketConn
+
+	// Sessions established or being established, indexed by remote address
+	mu     sync.Mutex
+	routes map[string]*route
+
+	// Relay requests waiting for a response, indexed by nonce
+	relays map[uint32]*pendingRelay
+
+	// Sessions of the peers we introduce, indexed by relay tag
+	relayTags *relayTags
+
+	// Peer tests we take part in
+	peerTests *peerTests
+
+	// Our reachability, as found by the peer tests
+	reachability *reachability
+
+	// Sessions established by remote peers and waiting to be accepted
+	accepted chan *Conn
+
+	// Cancelling ctx aborts the inbound handshakes in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+// route is the destination of the datagrams coming from a remote address
+type route struct {
+	dc *demuxConn
+
+	// The session, once established
+	conn *Conn
+}
+
+// NewTransport creates a Transport over the given socket, answering incoming session requests with the given options
+// The Transport takes ownership of the socket, which is closed along with it
+func NewTransport(pc net.PacketConn, opts *Dialer) *Transport {
+	// All the sessions share the same duplicate filter
+	if opts.Duplicates == nil {
+		o := *opts
+		o.Duplicates = newDefaultDuplicateFilter()
+		opts = &o
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Transport{
+		opts:         opts,
+		pc:           pc,
+		routes:       make(map[string]*route),
+		relays:       make(map[uint32]*pendingRelay),
+		relayTags:    newRelayTags(opts.MaxIntroducedPeers),
+		peerTests:    newPeerTests(),
+		reachability: newReachability(),
+		accepted:     make(chan *Conn),
+		ctx:          ctx,
+		cancel:       cancel,
+		closed:       make(chan struct{}),
+	}
+
+	// Start serving
+	go t.serve()
+
+	return t
+}
+
+// Dial does a direct dial to a peer over the shared socket, given its intro key and binary RouterIdentity
+func (t *Transport) Dial(ctx context.Context, peer *net.UDPAddr, introKey []byte, identity []byte) (*Conn, error) {
+	// Register the route to the peer
+	dc, err := t.register(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Establish the session
+	hs, err := t.opts.handshake(ctx, dc, peer, introKey, identity)
+	if err != nil {
+		dc.Close()
+		return nil, err
+	}
+	conn := hs.newConn(dc, false, t.sessionControl)
+	t.established(dc, conn)
+
+	return conn, nil
+}
+
+// Close closes the UDP socket, and with it all the sessions carried over it, which are destroyed
+// Any blocked Accept operations will be unblocked and return errors.
+func (t *Transport) Close() error {
+	t.close(net.ErrClosed)
+
+	// Close the sessions, destroying the established ones
+	t.mu.Lock()
+	routes := make([]route, 0, len(t.routes))
+	for _, r := range t.routes {
+		routes = append(routes, *r)
+	}
+	t.mu.Unlock()
+	for _, r := range routes {
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		r.dc.Close()
+	}
+
+	return t.pc.Close()
+}
+
+// close stops the transport with the given error
+func (t *Transport) close(err error) {
+	t.closeOnce.Do(func() {
+		t.err = err
+		t.cancel()
+		close(t.closed)
+		t.reachability.close()
+	})
+}
+
+// Addr returns the transport's network address.
+func (t *Transport) Addr() net.Addr { return t.pc.LocalAddr() }
+
+// register creates the route to a remote address, failing if there is already one
+func (t *Transport) register(addr net.Addr) (*demuxConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.closed:
+		return nil, t.err
+	default:
+	}
+
+	key := addr.String()
+	if _, ok := t.routes[key]; ok {
+		return nil, errors.New("a session with this peer already exists")
+	}
+
+	// The route is removed once its connection is closed
+	var r *route
+	dc := newDemuxConn(t.pc, addr, func() {
+		t.mu.Lock()
+		if t.routes[key] == r {
+			delete(t.routes, key)
+		}
+		t.mu.Unlock()
+	})
+	r = &route{dc: dc}
+	t.routes[key] = r
+
+	return dc, nil
+}
+
+// established records that the session over a route is established, its datagrams now being authenticated with its keys
+func (t *Transport) established(dc *demuxConn, conn *Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if r, ok := t.routes[dc.RemoteAddr().String()]; ok && r.dc == dc {
+		r.conn = conn
+	}
+}
+
+// serve reads datagrams from the UDP socket, and dispatches them by remote address
+/*
+As the spec requires, a datagram from a peer with an established session is first checked against the session's MAC key,
+then against our intro key. Datagrams from a peer with a handshake in progress are given to the handshake, which knows
+which key to expect. Datagrams from unknown peers are checked against our intro key.
+*/
+func (t *Transport) serve() {
+	buf := make([]byte, maximumDatagramSize)
+	for {
+		// Read a datagram
+		n, addr, err := t.pc.ReadFrom(buf)
+		if err != nil {
+			t.close(err)
+			return
+		}
+		b := make([]byte, n)
+		copy(b, buf[:n])
+
+		// Find its route
+		t.mu.Lock()
+		r, ok := t.routes[addr.String()]
+		var conn *Conn
+		if ok {
+			conn = r.conn
+		}
+		t.mu.Unlock()
+
+		switch {
+		case ok && (conn == nil || conn.authenticates(b)):
+			r.dc.deliver(b)
+		case n == 0:
+			t.handleHolePunch(addr)
+		default:
+			t.handleIntroKeyed(addr, b, ok)
+		}
+	}
+}
+
+// handleIntroKeyed handles a datagram using our intro key, which may start a new session if there is no route to its peer yet
+func (t *Transport) handleIntroKeyed(addr net.Addr, b []byte, routed bool) {
+	d := new(datagram)
+	if err := d.unmarshal(b, t.opts.Introkey, t.opts.Introkey); err != nil {
+		return
+	}
+	if err := checkClockSkew(d, time.Now(), t.opts.maxClockSkew()); err != nil {
+		t.opts.dropped(addr, err)
+		return
+	}
+
+	switch p, _, _ := decomposeFlag(d.Flag); p {
+	case payloadSessionRequest:
+		// Only a single session may exist with a peer
+		if routed {
+			return
+		}
+		dc, err := t.register(addr)
+		if err != nil {
+			return
+		}
+		go t.handshake(dc)
+		dc.deliver(b)
+	default:
+		t.handleControl(addr, nil, p, d.Payload)
+	}
+}
+
+// sessionControl handles the control messages received over an established session
+func (t *Transport) sessionControl(conn *Conn, payloadType byte, payload []byte) {
+	t.handleControl(conn.RemoteAddr(), conn, payloadType, payload)
+}
+
+// handleControl handles the messages other than session establishment, data and session destroyed
+// conn is the session they were received over, or nil if they used our intro key
+func (t *Transport) handleControl(addr net.Addr, conn *Conn, payloadType byte, payload []byte) {
+	switch payloadType {
+	case payloadRelayRequest:
+		t.handleRelayRequest(addr, payload)
+	case payloadRelayIntro:
+		t.handleRelayIntro(conn, payload)
+	case payloadRelayResponse:
+		t.handleRelayResponse(addr, payload)
+	case payloadPeerTest:
+		t.handlePeerTest(addr, conn, payload)
+	}
+}
+
+// writeControl sends a message other than data to a peer, over the session if one is established with it,
+// else using the given intro key
+func (t *Transport) writeControl(addr *net.UDPAddr, introKey []byte, payloadType byte, payload []byte) error {
+	// Find the session
+	t.mu.Lock()
+	r, ok := t.routes[addr.String()]
+	var conn *Conn
+	if ok {
+		conn = r.conn
+	}
+	t.mu.Unlock()
+	if conn != nil {
+		return conn.writeControl(payloadType, payload)
+	}
+
+	return t.writeIntroKeyed(addr, introKey, payloadType, payload)
+}
+
+// writeIntroKeyed sends a message to a peer using the given intro key, even if a session is established with it
+func (t *Transport) writeIntroKeyed(addr *net.UDPAddr, introKey []byte, payloadType byte, payload []byte) error {
+	// Embed it into a datagram using the intro key
+	d := &datagram{
+		Flag:    composeFlag(payloadType, false, false),
+		Time:    uint32(time.Now().Unix()),
+		Payload: payload,
+	}
+	b, err := d.MarshalBinary(introKey, introKey)
+	if err != nil {
+		return err
+	}
+
+	// Send it
+	_, err = t.pc.WriteTo(b, addr)
+	return err
+}
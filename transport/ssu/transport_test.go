@@ -0,0 +1,116 @@
+package ssu
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestTransport creates a Transport on the loopback, closed at the end of the test
+func newTestTransport(t *testing.T, opts *Dialer) *Transport {
+	tr, err := Listen("udp4", "127.0.0.1:0", opts)
+	if err != nil {
+		t.Fatalf("error in Listen: %v", err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+// dialTransport dials a Transport from another, returning both ends of the session
+func dialTransport(t *testing.T, from *Transport, to *Transport) (*Conn, *Conn) {
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := to.AcceptSSU()
+		if err != nil {
+			t.Errorf("error in AcceptSSU: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := from.Dial(ctx, to.Addr().(*net.UDPAddr), to.opts.Introkey, to.opts.RouterIdentity)
+	if err != nil {
+		t.Fatalf("error in Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	remote := <-accepted
+	if remote == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	return conn, remote
+}
+
+func TestTransport_SharedSocket(t *testing.T) {
+	a := newTestTransport(t, newTestDialer(t))
+	b := newTestTransport(t, newTestDialer(t))
+	c := newTestTransport(t, newTestDialer(t))
+
+	// A dials both B and C
+	ab, ba := dialTransport(t, a, b)
+	ac, ca := dialTransport(t, a, c)
+
+	// All of A's sessions share its socket
+	for _, conn := range []*Conn{ab, ac} {
+		if conn.LocalAddr().String() != a.Addr().String() {
+			t.Errorf("session bound to %v instead of %v", conn.LocalAddr(), a.Addr())
+		}
+	}
+
+	// Each message reaches its own session
+	for _, pair := range []struct{ from, to *Conn }{{ab, ba}, {ba, ab}, {ac, ca}, {ca, ac}} {
+		msg := []byte(pair.from.LocalAddr().String() + " to " + pair.to.LocalAddr().String())
+		if _, err := pair.from.Write(msg); err != nil {
+			t.Fatalf("error in Write: %v", err)
+		}
+		if got := readMessage(t, pair.to); !bytes.Equal(got, msg) {
+			t.Errorf("got %q, expected %q", got, msg)
+		}
+	}
+}
+
+func TestTransport_DialAndAccept(t *testing.T) {
+	a := newTestTransport(t, newTestDialer(t))
+	b := newTestTransport(t, newTestDialer(t))
+	c := newTestTransport(t, newTestDialer(t))
+
+	// A dials B while being dialed by C, over the same socket
+	ab, ba := dialTransport(t, a, b)
+	ca, ac := dialTransport(t, c, a)
+
+	for _, pair := range []struct{ from, to *Conn }{{ab, ba}, {ca, ac}, {ac, ca}} {
+		if _, err := pair.from.Write([]byte("hello")); err != nil {
+			t.Fatalf("error in Write: %v", err)
+		}
+		if got := readMessage(t, pair.to); string(got) != "hello" {
+			t.Errorf("got %q", got)
+		}
+	}
+
+	// A second session with the same peer is refused
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.Dial(ctx, b.Addr().(*net.UDPAddr), b.opts.Introkey, b.opts.RouterIdentity); err == nil {
+		t.Error("expected an error dialing a peer we already have a session with")
+	}
+}
+
+func TestTransport_Close(t *testing.T) {
+	a := newTestTransport(t, newTestDialer(t))
+	b := newTestTransport(t, newTestDialer(t))
+	ab, _ := dialTransport(t, a, b)
+
+	// Closing the transport closes its sessions
+	a.Close()
+	if _, err := ab.Write([]byte("hello")); err == nil {
+		t.Error("expected an error writing to a session of a closed transport")
+	}
+	if _, err := a.Accept(); err == nil {
+		t.Error("expected an error accepting on a closed transport")
+	}
+}